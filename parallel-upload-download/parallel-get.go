@@ -17,87 +17,728 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"log"
+	"math"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-type devNull int
+// Change this value to test with a different object size, it is used to
+// size the download buffers up front and is the default of -size.
+const defaultObjectSize = 10 * 1024 * 1024
 
-func (devNull) WriteAt(p []byte, off int64) (int, error) {
-	return len(p), nil
+// Timestamp layout used for the start and end of a run, as in
+// parallel-put.
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// Representations of the start and end timestamps, all in UTC.
+var timeFormats = []string{"iso8601", "rfc3339", "unix-millis"}
+
+// formatTimestamp formats t in UTC in one of timeFormats, the layout of
+// timestampFormat for iso8601.
+func formatTimestamp(t time.Time, format string) string {
+	t = t.UTC()
+	switch format {
+	case "rfc3339":
+		return t.Format(time.RFC3339Nano)
+	case "unix-millis":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return t.Format(timestampFormat)
+}
+
+// Log levels, messages below -log-level are dropped. The logger is kept
+// in sync with the one of parallel-put.go, each tool builds from its
+// single file.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// minLevel is the lowest level logged, set from -log-level.
+var minLevel = levelInfo
+
+// parseLevel returns the level named s.
+func parseLevel(s string) (int, error) {
+	for level, name := range levelNames {
+		if s == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// logf logs a message at level to stderr, stdout only holds the result.
+func logf(level int, format string, v ...interface{}) {
+	if level < minLevel {
+		return
+	}
+	log.Output(3, strings.ToUpper(levelNames[level])+" "+fmt.Sprintf(format, v...))
+}
+
+func debugf(format string, v ...interface{}) { logf(levelDebug, format, v...) }
+func infof(format string, v ...interface{})  { logf(levelInfo, format, v...) }
+func warnf(format string, v ...interface{})  { logf(levelWarn, format, v...) }
+func errorf(format string, v ...interface{}) { logf(levelError, format, v...) }
+
+// fatalf logs a message whatever the level and exits with status 1.
+func fatalf(format string, v ...interface{}) {
+	log.Output(2, "ERROR "+fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// Number of error messages printed when some downloads failed.
+const maxReportedErrors = 5
+
+// bufPool holds pointers to download buffers so that consecutive
+// downloads reuse the same memory instead of allocating a new buffer per
+// object. A buffer grown by a download is put back grown.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, defaultObjectSize)
+		return &buf
+	},
 }
 
-// Discard is an io.WriterAt on which
-// all WriteAt calls succeed without
-// doing anything.
-var Discard io.WriterAt = devNull(0)
+var (
+	logLevel    = flag.String("log-level", "info", "Lowest level of the messages logged to stderr, one of "+strings.Join(levelNames, ", ")+".")
+	verify      = flag.Bool("verify", false, "Check that every downloaded object holds the repeated 'a' bytes uploaded by parallel-put by default, or the size and MD5 of -read-manifest.")
+	verifyMD5s  = flag.String("verify-manifest", "", "Check the MD5 of every downloaded object against this manifest of parallel-put -write-manifest or -manifest or file of keys and MD5s, implies -verify.")
+	ifMatch     = flag.String("if-match", "", "Only download objects with this ETag, others are counted as precondition failed.")
+	ifNoneMatch = flag.String("if-none-match", "", "Only download objects without this ETag, others are counted as not modified.")
+	byteRange   = flag.String("range", "", "Only download this byte range of every object, first-last like 0-1048575 or the last bytes like last:64KiB.")
+	manifestIn  = flag.String("read-manifest", "", "Download the objects uploaded according to this file written by parallel-put -write-manifest or -manifest.")
+	prepare     = flag.Int("prepare", 0, "Upload this many objects of -size first and download only those, reported as PREP.")
+	timeFormat  = flag.String("time-format", "iso8601", "Format of the start and end timestamps, in UTC, one of "+strings.Join(timeFormats, ", ")+".")
+	anonymous   = flag.Bool("anonymous", false, "Send unsigned requests, for public buckets, instead of signing them with ACCESSKEY and SECRETKEY.")
+	workers     = flag.Int("workers", 0, "Maximum number of downloads running at the same time, 0 starts all of them at once.")
+	objectSize  = sizeFlag("size", defaultObjectSize, "Size of the objects uploaded with -prepare, also bounds -range when given, in bytes or with a unit such as 512KB or 10MiB.")
+)
+
+// rangeHeader and rangeLength hold the parsed -range, rangeLength is zero
+// when whole objects are downloaded.
+var (
+	rangeHeader string
+	rangeLength int64
+)
+
+// verifier reports whether the downloaded data of an object is the data
+// that was uploaded.
+type verifier func(objectName string, data []byte) bool
+
+// defaultPayloadByte is repeated over the whole default payload of
+// parallel-put.
+const defaultPayloadByte = 'a'
 
-// Downloads all object names in parallel.
-func parallelDownloads(objectNames []string) {
+// defaultPayload verifies the default payload of parallel-put.
+func defaultPayload(objectName string, data []byte) bool {
+	for _, b := range data {
+		if b != defaultPayloadByte {
+			return false
+		}
+	}
+	return true
+}
+
+// manifestObject is an object listed in a manifest. Two line formats are
+// read, the JSON lines written by parallel-put -write-manifest and
+// -manifest, of which only
+// the successful uploads are kept, and lines of a key and the hex MD5 of
+// its data separated by white space. Unknown JSON fields are ignored so
+// that parallel-put may add fields.
+type manifestObject struct {
+	Key     string `json:"key"`
+	Bucket  string `json:"bucket"`
+	Size    int64  `json:"size"`
+	ETag    string `json:"etag"`
+	Success bool   `json:"success"`
+
+	// md5 is the hex MD5 of a line of a key and an MD5, whose Size is
+	// not known and -1.
+	md5 string
+}
+
+// readManifest reads the objects of a manifest in either line format.
+func readManifest(path string) ([]manifestObject, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var objects []manifestObject
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "{") {
+			fields := strings.Fields(text)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s:%d: expected a key and an MD5", path, line)
+			}
+			objects = append(objects, manifestObject{Key: fields[0], Size: -1, md5: strings.ToLower(fields[1])})
+			continue
+		}
+		var o manifestObject
+		if err := json.Unmarshal([]byte(text), &o); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if o.Key == "" {
+			return nil, fmt.Errorf("%s:%d: expected a key", path, line)
+		}
+		if o.Success {
+			objects = append(objects, o)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// manifestVerifier returns a verifier comparing the size of the
+// downloaded data to the one of the manifest, when known, and its MD5 to
+// the one of the manifest or else to the ETag of single part uploads,
+// which is the MD5 of the data. Objects missing from the manifest fail
+// the verification.
+func manifestVerifier(objects []manifestObject) verifier {
+	byKey := map[string]manifestObject{}
+	for _, o := range objects {
+		byKey[o.Key] = o
+	}
+	return func(objectName string, data []byte) bool {
+		o, ok := byKey[objectName]
+		if !ok || (o.Size >= 0 && int64(len(data)) != o.Size) {
+			return false
+		}
+		want := o.md5
+		if etag := strings.Trim(o.ETag, `"`); want == "" && len(etag) == 2*md5.Size && !strings.Contains(etag, "-") {
+			want = strings.ToLower(etag)
+		}
+		if want == "" {
+			// Multipart ETags are not the MD5 of the data.
+			return true
+		}
+		sum := md5.Sum(data)
+		return hex.EncodeToString(sum[:]) == want
+	}
+}
+
+// downloadStats counts the downloads refused because of the -if-match
+// and -if-none-match conditions and the ranges cut short by the end of
+// the object.
+type downloadStats struct {
+	preconditionFailed int64
+	notModified        int64
+	shortRanges        int64
+}
+
+// count increments the counter matching err and reports whether err is
+// the outcome of a condition rather than a failure.
+func (c *downloadStats) count(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		return false
+	}
+	switch reqErr.StatusCode() {
+	case http.StatusPreconditionFailed:
+		atomic.AddInt64(&c.preconditionFailed, 1)
+	case http.StatusNotModified:
+		atomic.AddInt64(&c.notModified, 1)
+	default:
+		return false
+	}
+	return true
+}
+
+// refused returns the number of downloads refused by a condition, which
+// transferred no object.
+func (c *downloadStats) refused() int64 {
+	return atomic.LoadInt64(&c.preconditionFailed) + atomic.LoadInt64(&c.notModified)
+}
+
+// isAccessDenied reports whether err is a refusal of the credentials, or
+// of their absence.
+func isAccessDenied(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	return ok && (reqErr.StatusCode() == http.StatusForbidden || reqErr.Code() == "AccessDenied")
+}
+
+// Downloads all object names in parallel, at most workers at the same
+// time when workers is positive, all of them at once otherwise. Returns
+// the total number of bytes downloaded, when check is not nil the names
+// of the objects failing the check, and the failed downloads other than
+// those of a failed condition.
+func parallelDownloads(objectNames []string, workers int, check verifier, stats *downloadStats) (int64, []string, []error) {
+	if workers <= 0 || workers > len(objectNames) {
+		workers = len(objectNames)
+	}
+	var wg sync.WaitGroup
+	var totalSize int64
+	var mu sync.Mutex
+	var mismatched []string
+	var errs []error
+	// Every worker downloads the next object once done with the last one,
+	// so its buffer goes back to bufPool for the next download.
+	names := make(chan string)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for objectName := range names {
+				buf, err := downloadBlob(objectName)
+				data := *buf
+				switch {
+				case err != nil && stats.count(err):
+				case err != nil && *anonymous && isAccessDenied(err):
+					fatalf("%s: %v, the bucket does not allow anonymous reads", objectName, err)
+				case err != nil:
+					debugf("Download of %s failed: %v", objectName, err)
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", objectName, err))
+					mu.Unlock()
+				default:
+					debugf("Downloaded %s, %d bytes", objectName, len(data))
+					atomic.AddInt64(&totalSize, int64(len(data)))
+					if int64(len(data)) < rangeLength {
+						atomic.AddInt64(&stats.shortRanges, 1)
+					}
+					if check != nil && !check(objectName, data) {
+						mu.Lock()
+						mismatched = append(mismatched, objectName)
+						mu.Unlock()
+					}
+				}
+				bufPool.Put(buf)
+			}
+		}()
+	}
+	for _, objectName := range objectNames {
+		names <- objectName
+	}
+	close(names)
+	wg.Wait()
+	return totalSize, mismatched, errs
+}
+
+// Uploads all object names in parallel with size bytes each of the
+// default payload of parallel-put, so that -verify checks them. Upon any
+// error this function exits.
+func prepareObjects(objectNames []string, size int64) {
+	data := bytes.Repeat([]byte{defaultPayloadByte}, int(size))
 	var wg sync.WaitGroup
 	for _, objectName := range objectNames {
 		wg.Add(1)
 		go func(objectName string) {
 			defer wg.Done()
-			if err := downloadBlob(objectName); err != nil {
-				panic(err)
+			if err := uploadBlob(objectName, data); err != nil {
+				fatalf("Preparing %s failed: %v", objectName, err)
 			}
 		}(objectName)
 	}
 	wg.Wait()
 }
 
-// downloadBlob does an upload to the S3/Minio server
-func downloadBlob(objectName string) error {
-	credsUp := credentials.NewStaticCredentials(os.Getenv("ACCESSKEY"), os.Getenv("SECRETKEY"), "")
-	sessUp := session.New(aws.NewConfig().
-		WithCredentials(credsUp).
+// downloadNames returns the names of the conc objects downloaded, when
+// prepared is positive they go round the prepared objects.
+func downloadNames(nodeNumber string, conc, prepared int) []string {
+	var objectNames []string
+	for i := 0; i < conc; i++ {
+		n := i
+		if prepared > 0 {
+			n = i % prepared
+		}
+		objectNames = append(objectNames, fmt.Sprintf("object-%s-%d", nodeNumber, n+1))
+	}
+	return objectNames
+}
+
+// manifestNames returns the keys of the conc objects downloaded, going
+// round the objects of the manifest.
+func manifestNames(manifest []manifestObject, conc int) []string {
+	objectNames := make([]string, conc)
+	for i := range objectNames {
+		objectNames[i] = manifest[i%len(manifest)].Key
+	}
+	return objectNames
+}
+
+// newSession returns a session for the S3/Minio server of the
+// environment, its requests are not signed with -anonymous.
+func newSession() *session.Session {
+	creds := credentials.NewStaticCredentials(os.Getenv("ACCESSKEY"), os.Getenv("SECRETKEY"), "")
+	if *anonymous {
+		creds = credentials.AnonymousCredentials
+	}
+	return session.New(aws.NewConfig().
+		WithCredentials(creds).
 		WithRegion("us-east-1").
 		WithEndpoint(os.Getenv("ENDPOINT")).
 		WithS3ForcePathStyle(true))
+}
 
-	downloader := s3manager.NewDownloader(sessUp, func(u *s3manager.Downloader) {
+// uploadBlob does an upload to the S3/Minio server.
+func uploadBlob(objectName string, data []byte) error {
+	uploader := s3manager.NewUploader(newSession(), func(u *s3manager.Uploader) {
 		u.PartSize = 64 * 1024 * 1024 // 64MB per part
 	})
-
-	var err error
-	_, err = downloader.Download(Discard, &s3.GetObjectInput{
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Body:   bytes.NewReader(data),
 		Bucket: aws.String(os.Getenv("BUCKET")),
 		Key:    aws.String(objectName),
 	})
-
 	return err
 }
 
+// downloadBlob does a download from the S3/Minio server, the data is
+// held by the returned buffer, which is taken from bufPool and should be
+// put back once consumed.
+func downloadBlob(objectName string) (*[]byte, error) {
+	downloader := s3manager.NewDownloader(newSession(), func(u *s3manager.Downloader) {
+		u.PartSize = 64 * 1024 * 1024 // 64MB per part
+	})
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("BUCKET")),
+		Key:    aws.String(objectName),
+	}
+	if rangeHeader != "" {
+		input.Range = aws.String(rangeHeader)
+	}
+	if *ifMatch != "" {
+		input.IfMatch = aws.String(*ifMatch)
+	}
+	if *ifNoneMatch != "" {
+		input.IfNoneMatch = aws.String(*ifNoneMatch)
+	}
+	buf := bufPool.Get().(*[]byte)
+	w := aws.NewWriteAtBuffer((*buf)[:0])
+	_, err := downloader.Download(w, input)
+	*buf = w.Bytes()
+
+	return buf, err
+}
+
+// parseRange parses a -range of the form first-last, both inclusive, or
+// last:N for the last N bytes. Returns the Range header and the number of
+// bytes requested.
+func parseRange(s string) (string, int64, error) {
+	if suffix := strings.TrimPrefix(s, "last:"); suffix != s {
+		n, err := parseHumanNumber(suffix)
+		if err != nil {
+			return "", 0, err
+		}
+		if n <= 0 {
+			return "", 0, fmt.Errorf("invalid range %q, the number of bytes must be positive", s)
+		}
+		return fmt.Sprintf("bytes=-%d", n), n, nil
+	}
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return "", 0, fmt.Errorf("invalid range %q, expected first-last or last:N", s)
+	}
+	first, err := parseHumanNumber(bounds[0])
+	if err != nil {
+		return "", 0, err
+	}
+	last, err := parseHumanNumber(bounds[1])
+	if err != nil {
+		return "", 0, err
+	}
+	if first < 0 || last < first {
+		return "", 0, fmt.Errorf("invalid range %q, the last byte must not be before the first", s)
+	}
+	return fmt.Sprintf("bytes=%d-%d", first, last), last - first + 1, nil
+}
+
+// parseHumanNumber is kept in sync with the one of parallel-put.go, each
+// tool builds from its single file. KB and its siblings are powers of
+// 1000, KiB and its siblings powers of 1024.
+func parseHumanNumber(s string) (int64, error) {
+	multiplier := []int64{
+		1000,
+		1000 * 1000,
+		1000 * 1000 * 1000,
+		1000 * 1000 * 1000 * 1000,
+		1024,
+		1024 * 1024,
+		1024 * 1024 * 1024,
+		1024 * 1024 * 1024 * 1024,
+	}
+	suffixes := []string{
+		"KB", "MB", "GB", "TB",
+		"KiB", "MiB", "GiB", "TiB",
+	}
+	badSizeErr := fmt.Errorf("invalid size number %q given", s)
+	for i, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			v := strings.TrimSuffix(s, suffix)
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, badSizeErr
+			}
+			if n > math.MaxInt64/multiplier[i] || n < math.MinInt64/multiplier[i] {
+				return 0, fmt.Errorf("size %q overflows 64 bits", s)
+			}
+			return n * multiplier[i], nil
+		}
+	}
+	// try to parse raw byte number
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, badSizeErr
+	}
+	return n, nil
+}
+
+// byteSize is an int64 flag accepting the sizes of parseHumanNumber, such
+// as 10MB or 1GiB.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	n, err := parseHumanNumber(s)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("size %q must not be negative", s)
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// sizeFlag defines an int64 flag holding a size in bytes, given in bytes
+// or with a unit.
+func sizeFlag(name string, value int64, usage string) *int64 {
+	p := new(int64)
+	*p = value
+	flag.Var((*byteSize)(p), name, usage)
+	return p
+}
+
+// formatSize returns n in the largest unit dividing it, decimal units
+// first, for example 10MiB for 10485760 and 512KB for 512000.
+func formatSize(n int64) string {
+	if n > 0 {
+		for i := 3; i >= 0; i-- {
+			for _, u := range []struct {
+				base   int64
+				suffix string
+			}{{1000, "B"}, {1024, "iB"}} {
+				unit := int64(1)
+				for j := 0; j <= i; j++ {
+					unit *= u.base
+				}
+				if n%unit == 0 {
+					return fmt.Sprintf("%d%c%s", n/unit, "KMGT"[i], u.suffix)
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// rangeBound returns the object size a -range has to fit in and whether
+// it is known at all, which is size when sizeKnown is set and else the
+// largest object of manifest whose size is known.
+func rangeBound(size int64, sizeKnown bool, manifest []manifestObject) (int64, bool) {
+	if sizeKnown {
+		return size, true
+	}
+	largest, known := int64(0), false
+	for _, o := range manifest {
+		if o.Size >= 0 {
+			known = true
+			if o.Size > largest {
+				largest = o.Size
+			}
+		}
+	}
+	return largest, known
+}
+
 func main() {
+	flag.Parse()
+	level, err := parseLevel(*logLevel)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	minLevel = level
+	if *prepare < 0 || *objectSize < 0 {
+		fatalf("-prepare and -size must not be negative")
+	}
+	if *prepare > 0 && *objectSize > math.MaxInt {
+		// The prepared payload is held in memory.
+		fatalf("-size %d exceeds the %d bytes -prepare holds in memory", *objectSize, math.MaxInt)
+	}
+	sizeGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "size" {
+			sizeGiven = true
+			infof("Object size is %s, %d bytes", formatSize(*objectSize), *objectSize)
+		}
+	})
+	knownFormat := false
+	for _, format := range timeFormats {
+		knownFormat = knownFormat || *timeFormat == format
+	}
+	if !knownFormat {
+		fatalf("Unknown -time-format %q, expected one of %s", *timeFormat, strings.Join(timeFormats, ", "))
+	}
+	if *anonymous {
+		if *prepare > 0 {
+			fatalf("-prepare uploads objects, which requires credentials, and cannot be combined with -anonymous")
+		}
+		infof("Sending unsigned requests, the bucket has to allow anonymous reads")
+	}
+	var manifest []manifestObject
+	if *manifestIn != "" {
+		if *prepare > 0 {
+			fatalf("-read-manifest and -prepare are mutually exclusive")
+		}
+		var err error
+		if manifest, err = readManifest(*manifestIn); err != nil {
+			fatalf("%v", err)
+		}
+		if len(manifest) == 0 {
+			fatalf("%s lists no uploaded objects", *manifestIn)
+		}
+		for _, o := range manifest {
+			if o.Bucket != manifest[0].Bucket {
+				fatalf("%s lists objects of buckets %s and %s, only one is supported", *manifestIn, manifest[0].Bucket, o.Bucket)
+			}
+		}
+		// BUCKET takes precedence, for example to replay the manifest
+		// against a copy of the bucket.
+		if os.Getenv("BUCKET") == "" {
+			os.Setenv("BUCKET", manifest[0].Bucket)
+		}
+		infof("Read %d objects of bucket %s from %s", len(manifest), os.Getenv("BUCKET"), *manifestIn)
+	}
+	if *byteRange != "" {
+		var err error
+		if rangeHeader, rangeLength, err = parseRange(*byteRange); err != nil {
+			fatalf("%v", err)
+		}
+		if *verifyMD5s != "" {
+			fatalf("-verify-manifest checks whole objects and cannot be combined with -range")
+		}
+		if *verify && manifest != nil {
+			fatalf("-verify checks whole objects with -read-manifest and cannot be combined with -range")
+		}
+		if bound, known := rangeBound(*objectSize, sizeGiven || *prepare > 0, manifest); known && rangeLength > bound {
+			fatalf("Range of %d bytes exceeds the object size of %d bytes", rangeLength, bound)
+		}
+	}
+	var check verifier
+	switch {
+	case *verifyMD5s != "":
+		objects, err := readManifest(*verifyMD5s)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		check = manifestVerifier(objects)
+	case *verify && manifest != nil:
+		check = manifestVerifier(manifest)
+	case *verify:
+		check = defaultPayload
+	}
+
 	concurrency := os.Getenv("CONCURRENCY")
+	if concurrency == "" && manifest != nil {
+		// Every object of the manifest is downloaded once.
+		concurrency = strconv.Itoa(len(manifest))
+	}
 	nodeNumber := os.Getenv("NODE")
 	conc, err := strconv.Atoi(concurrency)
 	if err != nil {
-		log.Fatalln(err)
+		fatalf("%v", err)
 	}
 
-	var objectNames []string
-	for i := 0; i < conc; i++ {
-		objectNames = append(objectNames, fmt.Sprintf("object-%s-%d", nodeNumber, i+1))
+	if *prepare > 0 {
+		// Timed apart from the downloads, which only go to the
+		// prepared objects.
+		prepStart := time.Now().UTC()
+		prepareObjects(downloadNames(nodeNumber, *prepare, 0), *objectSize)
+		prepElapsed := time.Since(prepStart)
+		prepSeconds := float64(prepElapsed) / float64(time.Second)
+		prepBytes := int64(*prepare) * *objectSize
+		fmt.Printf("PREP;%s;%d;%d;%d;%d;%s;%f;%f;%s;%s\n", nodeNumber, *prepare, *objectSize, 0, 0, prepElapsed, float64(*prepare)/prepSeconds, float64(prepBytes)/prepSeconds/1024/1024, formatTimestamp(prepStart, *timeFormat), formatTimestamp(time.Now(), *timeFormat))
+	}
+	objectNames := downloadNames(nodeNumber, conc, *prepare)
+	if manifest != nil {
+		objectNames = manifestNames(manifest, conc)
 	}
 
 	start := time.Now().UTC()
-	parallelDownloads(objectNames)
-	totalSize := conc * 10485760
+	var stats downloadStats
+	totalSize, mismatched, errs := parallelDownloads(objectNames, *workers, check, &stats)
+	// Failed downloads and those refused by -if-match or -if-none-match
+	// are left out of the speed and the object size.
+	downloaded := int64(conc-len(errs)) - stats.refused()
+	var objectSize int64
+	if downloaded > 0 {
+		objectSize = totalSize / downloaded
+	}
+
 	elapsed := time.Since(start)
 	seconds := float64(elapsed) / float64(time.Second)
-	//fmt.Println("Type;Node Number;Concurrency;Elapsed Time;Speed (objs/sec);Bandwidth (MBit/sec);Start Timestamp;End Timestamp")
-	fmt.Printf("GET;%s;%s;%s;%f;%f;%s;%s\n", nodeNumber, concurrency, elapsed, float64(conc)/seconds, float64(totalSize)/seconds/1024/1024, start.Format("2006-01-02T15:04:05.000Z"), time.Now().Format("2006-01-02T15:04:05.000Z"))
+	// Metadata is not fetched on GET, the columns are kept so that the
+	// row lines up with the one printed by parallel-put.
+	//fmt.Println("Type;Node Number;Concurrency;Object Size (bytes);Metadata Entries;Metadata Size (bytes);Elapsed Time;Speed (objs/sec);Bandwidth (MBit/sec);Start Timestamp;End Timestamp")
+	fmt.Printf("GET;%s;%s;%d;%d;%d;%s;%f;%f;%s;%s\n", nodeNumber, concurrency, objectSize, 0, 0, elapsed, float64(downloaded)/seconds, float64(totalSize)/seconds/1024/1024, formatTimestamp(start, *timeFormat), formatTimestamp(time.Now(), *timeFormat))
+
+	if rangeHeader != "" {
+		infof("Downloaded %s of every object, %d ranges were cut short by the end of the object", rangeHeader, stats.shortRanges)
+	}
+	if *ifMatch != "" || *ifNoneMatch != "" {
+		infof("%d downloads failed the precondition, %d were not modified", stats.preconditionFailed, stats.notModified)
+	}
+	if check != nil {
+		infof("Verified %d objects, %d did not match", downloaded, len(mismatched))
+		for _, objectName := range mismatched {
+			errorf("Mismatch: %s", objectName)
+		}
+	}
+	if len(errs) > 0 {
+		errorf("%d of %d downloads failed", len(errs), len(objectNames))
+		for i, err := range errs {
+			if i == maxReportedErrors {
+				errorf("... and %d more", len(errs)-maxReportedErrors)
+				break
+			}
+			errorf("%v", err)
+		}
+	}
+	if len(mismatched) > 0 || len(errs) > 0 {
+		os.Exit(1)
+	}
 }
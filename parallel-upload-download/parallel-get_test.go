@@ -0,0 +1,415 @@
+/*
+ * Minio Cloud Storage (C) 2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Tests that only the default payload of parallel-put passes the default
+// verification.
+func TestDefaultPayload(t *testing.T) {
+	if !defaultPayload("object-1-1", bytes.Repeat([]byte("a"), 1024)) {
+		t.Fatal("expected the default payload to match")
+	}
+	if defaultPayload("object-1-1", make([]byte, 1024)) {
+		t.Fatal("expected zero bytes to mismatch")
+	}
+	if defaultPayload("object-1-1", []byte("aab")) {
+		t.Fatal("expected another byte to mismatch")
+	}
+}
+
+// Tests the verification of downloads against a manifest of keys and
+// MD5s.
+func TestManifestMD5s(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest")
+	manifest := "object-1-1 5D41402ABC4B2A76B9719D911017C592\n\nobject-1-2\td41d8cd98f00b204e9800998ecf8427e\n"
+	if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := readManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := manifestVerifier(objects)
+	for _, tc := range []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"object-1-1", "hello", true},
+		{"object-1-1", "hellO", false},
+		{"object-1-2", "", true},
+		{"object-1-3", "", false},
+	} {
+		if got := check(tc.name, []byte(tc.data)); got != tc.want {
+			t.Errorf("%s with %q: expected %t, got %t", tc.name, tc.data, tc.want, got)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("object-1-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readManifest(path); err == nil {
+		t.Fatal("expected a line without an MD5 to fail")
+	}
+}
+
+// Tests that the successful uploads of parallel-put -write-manifest are read
+// and verified by size and, for single part ETags, by MD5.
+func TestReadManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest")
+	manifest := `{"key":"object-1-1","bucket":"bucket","size":5,"start":"2017-01-02T03:04:05Z","latencyMs":1,"success":true,"etag":"5d41402abc4b2a76b9719d911017c592"}` + "\n\n" +
+		`{"key":"object-1-2","bucket":"bucket","size":3,"success":true,"etag":"0123456789abcdef0123456789abcdef-2","added":true}` + "\n" +
+		`{"key":"object-1-3","bucket":"bucket","size":0,"success":false,"error":"upload failed"}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := readManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[1].Bucket != "bucket" || objects[1].Size != 3 {
+		t.Fatalf("expected the 2 uploaded objects, got %+v", objects)
+	}
+	if got := fmt.Sprint(manifestNames(objects, 3)); got != "[object-1-1 object-1-2 object-1-1]" {
+		t.Fatalf("unexpected download names %s", got)
+	}
+	check := manifestVerifier(objects)
+	for _, tc := range []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"object-1-1", "hello", true},
+		{"object-1-1", "hellO", false},
+		{"object-1-2", "abc", true},
+		{"object-1-2", "abcd", false},
+		{"object-1-3", "", false},
+	} {
+		if got := check(tc.name, []byte(tc.data)); got != tc.want {
+			t.Errorf("%s with %q: expected %t, got %t", tc.name, tc.data, tc.want, got)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"bucket":"bucket","success":true}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readManifest(path); err == nil {
+		t.Fatal("expected a line without a key to fail")
+	}
+}
+
+// Tests that downloads refused by -if-match and -if-none-match are
+// counted instead of failing the run.
+func TestConditionalDownloads(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bucket/object-1-1":
+			w.Header().Set("Content-Range", "bytes 0-4/5")
+			w.WriteHeader(http.StatusPartialContent)
+			fmt.Fprint(w, "hello")
+		case "/bucket/object-1-2":
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>failed</Message></Error>`)
+		default:
+			w.WriteHeader(http.StatusNotModified)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("ENDPOINT", srv.URL)
+	t.Setenv("ACCESSKEY", "minio")
+	t.Setenv("SECRETKEY", "minio123")
+	t.Setenv("BUCKET", "bucket")
+
+	var stats downloadStats
+	total, _, errs := parallelDownloads([]string{"object-1-1", "object-1-2", "object-1-3"}, 0, nil, &stats)
+	if len(errs) != 0 {
+		t.Fatalf("expected the failed conditions not to be errors, got %v", errs)
+	}
+	if total != 5 {
+		t.Fatalf("expected 5 bytes downloaded, got %d", total)
+	}
+	if stats.preconditionFailed != 1 || stats.notModified != 1 {
+		t.Fatalf("expected 1 precondition failed and 1 not modified, got %d and %d", stats.preconditionFailed, stats.notModified)
+	}
+	if stats.refused() != 2 {
+		t.Fatalf("expected 2 refused downloads out of the rates, got %d", stats.refused())
+	}
+}
+
+// Tests that requests are only signed without -anonymous and that a
+// refused anonymous read is told apart.
+func TestAnonymousDownloads(t *testing.T) {
+	var mu sync.Mutex
+	var authorizations []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("Content-Range", "bytes 0-4/5")
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, "hello")
+	}))
+	defer srv.Close()
+	t.Setenv("ENDPOINT", srv.URL)
+	t.Setenv("ACCESSKEY", "minio")
+	t.Setenv("SECRETKEY", "minio123")
+	t.Setenv("BUCKET", "bucket")
+	defer func() { *anonymous = false }()
+	for _, anon := range []bool{false, true} {
+		*anonymous = anon
+		data, err := downloadBlob("object-1-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(*data) != "hello" {
+			t.Fatalf("expected hello, got %q", *data)
+		}
+		bufPool.Put(data)
+	}
+	if len(authorizations) != 2 || authorizations[0] == "" || authorizations[1] != "" {
+		t.Fatalf("expected only the first download to be signed, got %q", authorizations)
+	}
+
+	denied := awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), http.StatusForbidden, "")
+	if !isAccessDenied(denied) {
+		t.Fatal("expected AccessDenied to be a refusal")
+	}
+	notFound := awserr.NewRequestFailure(awserr.New("NoSuchKey", "missing", nil), http.StatusNotFound, "")
+	if isAccessDenied(notFound) {
+		t.Fatal("expected NoSuchKey not to be a refusal")
+	}
+}
+
+// Tests that the prepared objects are uploaded and that the downloads go
+// round exactly those keys.
+func TestPrepareObjects(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("ENDPOINT", srv.URL)
+	t.Setenv("ACCESSKEY", "minio")
+	t.Setenv("SECRETKEY", "minio123")
+	t.Setenv("BUCKET", "bucket")
+
+	prepareObjects(downloadNames("1", 2, 0), 1024)
+	if len(objects) != 2 || len(objects["/bucket/object-1-2"]) != 1024 {
+		t.Fatalf("unexpected prepared objects %d", len(objects))
+	}
+	names := downloadNames("1", 5, 2)
+	if got := fmt.Sprint(names); got != "[object-1-1 object-1-2 object-1-1 object-1-2 object-1-1]" {
+		t.Fatalf("unexpected download names %s", got)
+	}
+	var stats downloadStats
+	total, mismatched, errs := parallelDownloads(names, 2, defaultPayload, &stats)
+	if total != 5*1024 || len(mismatched) != 0 || len(errs) != 0 {
+		t.Fatalf("expected 5 verified downloads of 1024 bytes, got %d bytes, mismatches %v and errors %v", total, mismatched, errs)
+	}
+}
+
+// Tests that a failed download is collected with its key while the other
+// downloads of the same worker go on.
+func TestDownloadErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bucket/object-1-2" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 0-4/5")
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, "hello")
+	}))
+	defer srv.Close()
+	t.Setenv("ENDPOINT", srv.URL)
+	t.Setenv("ACCESSKEY", "minio")
+	t.Setenv("SECRETKEY", "minio123")
+	t.Setenv("BUCKET", "bucket")
+
+	var stats downloadStats
+	total, _, errs := parallelDownloads(downloadNames("1", 3, 0), 1, nil, &stats)
+	if total != 10 {
+		t.Fatalf("expected 10 bytes downloaded, got %d", total)
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "object-1-2: NoSuchKey") {
+		t.Fatalf("expected the download of object-1-2 to fail, got %v", errs)
+	}
+}
+
+// Tests that messages below -log-level are dropped and that the others
+// name their level, as in parallel-put.
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	level, err := parseLevel("warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	minLevel = level
+	defer func() { minLevel = levelInfo }()
+	infof("dropped")
+	warnf("shown %d", 1)
+	if out := buf.String(); strings.Contains(out, "dropped") || !strings.Contains(out, "WARN shown 1") {
+		t.Fatalf("expected only the warning to be logged, got %q", out)
+	}
+	if _, err := parseLevel("trace"); err == nil {
+		t.Fatal("expected an unknown level to be rejected")
+	}
+}
+
+// Tests that start and end timestamps use UTC whatever the zone of the
+// clock reading, in every format.
+func TestFormatTimestamp(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 30, 15, 250*int(time.Millisecond), time.UTC)
+	end := start.Add(1500 * time.Millisecond).In(time.FixedZone("CET", 3600))
+	for _, tc := range []struct {
+		format     string
+		start, end string
+	}{
+		{"iso8601", "2024-03-01T12:30:15.250Z", "2024-03-01T12:30:16.750Z"},
+		{"rfc3339", "2024-03-01T12:30:15.25Z", "2024-03-01T12:30:16.75Z"},
+		{"unix-millis", "1709296215250", "1709296216750"},
+	} {
+		if got := formatTimestamp(start, tc.format); got != tc.start {
+			t.Errorf("%s: expected start %s, got %s", tc.format, tc.start, got)
+		}
+		if got := formatTimestamp(end, tc.format); got != tc.end {
+			t.Errorf("%s: expected end %s, got %s", tc.format, tc.end, got)
+		}
+	}
+	local := time.Now()
+	if formatTimestamp(local, "iso8601") != formatTimestamp(local.UTC(), "iso8601") {
+		t.Fatal("expected the local time to be formatted in UTC")
+	}
+}
+
+// Tests that sizes are accepted with units and echoed in the largest unit
+// dividing them.
+func TestByteSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+		echo string
+	}{
+		{"10485760", 10485760, "10MiB"},
+		{"10MB", 10000000, "10MB"},
+		{"1GiB", 1 << 30, "1GiB"},
+		{"4TiB", 4 << 40, "4TiB"},
+		{"512KB", 512000, "512KB"},
+		{"1536", 1536, "1536 bytes"},
+		{"0", 0, "0 bytes"},
+	} {
+		var b byteSize
+		if err := b.Set(tc.in); err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		if int64(b) != tc.want || b.String() != fmt.Sprint(tc.want) {
+			t.Fatalf("%s: expected %d, got %s", tc.in, tc.want, b.String())
+		}
+		if got := formatSize(int64(b)); got != tc.echo {
+			t.Fatalf("%s: expected %s, got %s", tc.in, tc.echo, got)
+		}
+	}
+	for _, in := range []string{"10M", "-1", "-1KB", "1.5MB"} {
+		var b byteSize
+		if err := b.Set(in); err == nil {
+			t.Fatalf("%s: expected an error", in)
+		}
+	}
+	if n, err := parseHumanNumber("9000000TiB"); err == nil {
+		t.Fatalf("expected an overflowing size to fail, got %d", n)
+	}
+}
+
+// Tests the parsing of -range into a Range header.
+func TestParseRange(t *testing.T) {
+	for _, tc := range []struct {
+		in     string
+		header string
+		length int64
+	}{
+		{"0-1048575", "bytes=0-1048575", 1048576},
+		{"100-100", "bytes=100-100", 1},
+		{"1MiB-2MiB", "bytes=1048576-2097152", 1048577},
+		{"last:64KiB", "bytes=-65536", 65536},
+	} {
+		header, length, err := parseRange(tc.in)
+		if err != nil || header != tc.header || length != tc.length {
+			t.Errorf("%s: expected %s and %d, got %s, %d and error %v", tc.in, tc.header, tc.length, header, length, err)
+		}
+	}
+	for _, in := range []string{"", "10", "10-5", "a-b", "last:0", "last:x", "0-9000000TiB"} {
+		if _, _, err := parseRange(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+// Tests that a range is only bounded by an object size that is known.
+func TestRangeBound(t *testing.T) {
+	if _, known := rangeBound(defaultObjectSize, false, nil); known {
+		t.Fatal("expected the default size not to bound the range")
+	}
+	if bound, known := rangeBound(1024, true, nil); !known || bound != 1024 {
+		t.Fatalf("expected the given size to bound the range, got %d and %t", bound, known)
+	}
+	manifest := []manifestObject{{Key: "a", Size: 100}, {Key: "b", Size: 300}, {Key: "c", Size: 200}}
+	if bound, known := rangeBound(defaultObjectSize, false, manifest); !known || bound != 300 {
+		t.Fatalf("expected the largest object to bound the range, got %d and %t", bound, known)
+	}
+	if bound, _ := rangeBound(1024, true, manifest); bound != 1024 {
+		t.Fatalf("expected the given size to take precedence, got %d", bound)
+	}
+	if _, known := rangeBound(defaultObjectSize, false, []manifestObject{{Key: "a", Size: -1}}); known {
+		t.Fatal("expected objects of unknown size not to bound the range")
+	}
+}
@@ -17,20 +17,57 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-	"math/rand"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Change this value to test with a different object size.
@@ -39,91 +76,5347 @@ const defaultObjectSize = 10 * 1024 * 1024
 const defaultMetaCount = 1
 const defaultMetaSize = 1024
 
+const defaultRegion = "us-east-1"
+
+const defaultPartSize = 64 * 1024 * 1024 // 64MB per part
+
+// Maximum number of keys accepted by a single DeleteObjects call.
+const maxDeleteBatch = 1000
+
+// Operations that can be benchmarked with -op.
+var operations = []string{"put", "presigned-put", "put-get", "manual-multipart", "copy", "delete", "head", "list"}
+
+func isOperation(op string) bool {
+	for _, o := range operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of error messages printed when some uploads failed.
+const maxReportedErrors = 5
+
+// Share of the -rate target that counts as sustained.
+const rateSustainedShare = 0.95
+
+// Build information of the tool, set at build time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// The version is reported in the default User-Agent.
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// buildInfo returns the version, commit and build date of the tool.
+func buildInfo() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", version, commit, buildDate)
+}
+
+// Version of the JSON result layout, bump it whenever the fields change.
+const resultSchemaVersion = 17
+
+// Timestamp layout used for the start and end of a run.
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// Representations of the start and end timestamps, all in UTC.
+var timeFormats = []string{"iso8601", "rfc3339", "unix-millis"}
+
+// formatTimestamp formats t in UTC in one of timeFormats, the layout of
+// timestampFormat for iso8601.
+func formatTimestamp(t time.Time, format string) string {
+	t = t.UTC()
+	switch format {
+	case "rfc3339":
+		return t.Format(time.RFC3339Nano)
+	case "unix-millis":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return t.Format(timestampFormat)
+}
+
+// Log levels, messages below -log-level are dropped.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// minLevel is the lowest level logged, set from -log-level.
+var minLevel = levelInfo
+
+// parseLevel returns the level named s.
+func parseLevel(s string) (int, error) {
+	for level, name := range levelNames {
+		if s == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// logf logs a message at level to stderr, stdout only holds the result.
+func logf(level int, format string, v ...interface{}) {
+	if level < minLevel {
+		return
+	}
+	log.Output(3, strings.ToUpper(levelNames[level])+" "+fmt.Sprintf(format, v...))
+}
+
+func debugf(format string, v ...interface{}) { logf(levelDebug, format, v...) }
+func infof(format string, v ...interface{})  { logf(levelInfo, format, v...) }
+func warnf(format string, v ...interface{})  { logf(levelWarn, format, v...) }
+func errorf(format string, v ...interface{}) { logf(levelError, format, v...) }
+
+// fatalf logs a message whatever the level and exits with status 1.
+func fatalf(format string, v ...interface{}) {
+	log.Output(2, "ERROR "+fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
-func randStringBytes(n int) string {
+func randStringBytes(rng *rand.Rand, n int) string {
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+		b[i] = letterBytes[rng.Intn(len(letterBytes))]
 	}
 	return string(b)
 }
 
-// Uploads all the inputs objects in parallel, upon any error this function panics.
-func parallelUploads(objectNames []string, data []byte, metaCount int, metaSize int) {
+// Character sets of the metadata values.
+var metaCharsets = []string{"ascii", "unicode", "binary-base64"}
+
+// Multi-byte characters of unicode metadata values, from two to four
+// bytes in UTF-8.
+var unicodeRunes = []rune("äöüßéñøåçł€ΩπЖЯ中文日本語한국어🙂🚀")
+
+// S3 limit on the user metadata of an object, the sum of the UTF-8 bytes
+// of every key and value.
+const maxUserMetadataSize = 2048
+
+// metaValue returns a metadata value of n bytes in charset drawn from rng.
+// Unicode values mix multi-byte characters with ASCII letters, base64
+// values encode random bytes.
+func metaValue(rng *rand.Rand, charset string, n int) string {
+	switch charset {
+	case "unicode":
+		b := make([]byte, 0, n)
+		for len(b) < n {
+			r := unicodeRunes[rng.Intn(len(unicodeRunes))]
+			if len(b)+utf8.RuneLen(r) > n || rng.Intn(2) == 0 {
+				b = append(b, letterBytes[rng.Intn(len(letterBytes))])
+				continue
+			}
+			b = utf8.AppendRune(b, r)
+		}
+		return string(b)
+	case "binary-base64":
+		raw := make([]byte, base64.RawStdEncoding.DecodedLen(n)+1)
+		rng.Read(raw)
+		return base64.RawStdEncoding.EncodeToString(raw)[:n]
+	}
+	return randStringBytes(rng, n)
+}
+
+// objectRand returns a random number generator for objectName, seeded so
+// that the same seed and name give the same values no matter in which
+// order the objects are uploaded.
+func objectRand(seed int64, objectName string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(objectName))
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
+
+// latencies collects the durations of the successful uploads.
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.samples = append(l.samples, d)
+	l.mu.Unlock()
+}
+
+// latencyStats summarizes the upload latencies in milliseconds.
+type latencyStats struct {
+	MinMs float64 `json:"minMs"`
+	P50Ms float64 `json:"p50Ms"`
+	P90Ms float64 `json:"p90Ms"`
+	P99Ms float64 `json:"p99Ms"`
+	MaxMs float64 `json:"maxMs"`
+}
+
+// stats computes the latency percentiles of the collected samples using
+// the nearest rank method.
+func (l *latencies) stats() *latencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) == 0 {
+		return &latencyStats{}
+	}
+	sorted := make([]time.Duration, len(l.samples))
+	copy(sorted, l.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	rank := func(p float64) time.Duration {
+		i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return &latencyStats{
+		MinMs: ms(sorted[0]),
+		P50Ms: ms(rank(50)),
+		P90Ms: ms(rank(90)),
+		P99Ms: ms(rank(99)),
+		MaxMs: ms(sorted[len(sorted)-1]),
+	}
+}
+
+// histogramBucketsPerOctave is the number of histogram buckets between a
+// latency and its double, so that every bucket spans about 19% and sub-ms
+// as well as multi-second latencies are told apart.
+const histogramBucketsPerOctave = 4
+
+// histogramMin is the upper bound of the first histogram bucket, which
+// holds every shorter latency.
+const histogramMin = time.Microsecond
+
+// histogramBucket counts the latencies from LowerMs to UpperMs excluded.
+type histogramBucket struct {
+	LowerMs float64
+	UpperMs float64
+	Count   int
+}
+
+// histogramBound returns the upper bound of the i-th histogram bucket.
+func histogramBound(i int) time.Duration {
+	return time.Duration(float64(histogramMin) * math.Pow(2, float64(i)/histogramBucketsPerOctave))
+}
+
+// histogram buckets the collected samples on a logarithmic scale. The
+// bounds do not depend on the samples so that the histograms of different
+// runs can be overlaid, and the empty buckets between the shortest and the
+// longest latency are kept.
+func (l *latencies) histogram() []histogramBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) == 0 {
+		return nil
+	}
+	counts := map[int]int{}
+	first, last := math.MaxInt32, 0
+	for _, d := range l.samples {
+		i := 0
+		if d >= histogramMin {
+			i = int(math.Log2(float64(d)/float64(histogramMin))*histogramBucketsPerOctave) + 1
+			// Rounding may put a sample next to its bucket.
+			for i > 0 && d < histogramBound(i-1) {
+				i--
+			}
+			for d >= histogramBound(i) {
+				i++
+			}
+		}
+		counts[i]++
+		if i < first {
+			first = i
+		}
+		if i > last {
+			last = i
+		}
+	}
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	buckets := make([]histogramBucket, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		b := histogramBucket{UpperMs: ms(histogramBound(i)), Count: counts[i]}
+		if i > 0 {
+			b.LowerMs = ms(histogramBound(i - 1))
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// logThrottle lets at most max messages through per interval so that a
+// condition hit by every operation does not flood stderr.
+type logThrottle struct {
+	mu       sync.Mutex
+	max      int
+	interval time.Duration
+	start    time.Time
+	allowed  int
+	dropped  int
+}
+
+// allow reports whether a message may be logged at now, together with
+// the number of messages dropped since the last one let through.
+func (t *logThrottle) allow(now time.Time) (bool, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.start) >= t.interval {
+		t.start, t.allowed = now, 0
+	}
+	if t.allowed >= t.max {
+		t.dropped++
+		return false, 0
+	}
+	t.allowed++
+	dropped := t.dropped
+	t.dropped = 0
+	return true, dropped
+}
+
+// Slow uploads logged per second at most, the others are only counted.
+const maxSlowLogsPerSec = 10
+
+// resourceStats is the resource use of the client while a row was
+// measured, to tell a saturated client from a saturated backend.
+type resourceStats struct {
+	CPUSeconds     float64 `json:"cpuSeconds"`
+	PeakGoroutines int64   `json:"peakGoroutines"`
+	AllocBytes     uint64  `json:"allocBytes"`
+	Allocs         uint64  `json:"allocs"`
+}
+
+// Interval at which the number of goroutines is sampled.
+const goroutineSampleInterval = 100 * time.Millisecond
+
+// resourceSampler measures the resource use of the process from one row
+// to the next. Only the goroutines are sampled while uploading, the CPU
+// time and the memory statistics are read between the rows.
+type resourceSampler struct {
+	peak    int64
+	cpu     time.Duration
+	alloc   uint64
+	mallocs uint64
+	done    chan struct{}
+}
+
+func newResourceSampler() *resourceSampler {
+	s := &resourceSampler{done: make(chan struct{})}
+	s.reset()
+	go func() {
+		ticker := time.NewTicker(goroutineSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.observeGoroutines()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *resourceSampler) observeGoroutines() {
+	n := int64(runtime.NumGoroutine())
+	for {
+		peak := atomic.LoadInt64(&s.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&s.peak, peak, n) {
+			return
+		}
+	}
+}
+
+// processCPUTime returns the user and system CPU time of the process.
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// reset starts measuring from now.
+func (s *resourceSampler) reset() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.cpu, s.alloc, s.mallocs = processCPUTime(), mem.TotalAlloc, mem.Mallocs
+	atomic.StoreInt64(&s.peak, int64(runtime.NumGoroutine()))
+}
+
+// sample returns the resource use since the last sample or reset and
+// starts measuring the next row.
+func (s *resourceSampler) sample() *resourceStats {
+	s.observeGoroutines()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := &resourceStats{
+		CPUSeconds:     (processCPUTime() - s.cpu).Seconds(),
+		PeakGoroutines: atomic.LoadInt64(&s.peak),
+		AllocBytes:     mem.TotalAlloc - s.alloc,
+		Allocs:         mem.Mallocs - s.mallocs,
+	}
+	s.reset()
+	return stats
+}
+
+func (s *resourceSampler) stop() {
+	close(s.done)
+}
+
+// slaExitCode is the exit code of a run whose operations succeeded but
+// that did not meet one of the -sla thresholds.
+const slaExitCode = 2
+
+// sla holds the thresholds every reported row must meet, a zero value
+// disables its threshold.
+type sla struct {
+	p99           time.Duration
+	minThroughput float64
+}
+
+// violations describes every threshold the row r with the latencies lat
+// did not meet.
+func (s sla) violations(r result, lat *latencyStats) []string {
+	var v []string
+	if s.p99 > 0 {
+		p99 := time.Duration(lat.P99Ms * float64(time.Millisecond))
+		if p99 > s.p99 {
+			v = append(v, fmt.Sprintf("%s p99 latency %s is above -sla-p99 %s", r.Type, p99, s.p99))
+		}
+	}
+	if s.minThroughput > 0 && r.ObjsPerSec < s.minThroughput {
+		v = append(v, fmt.Sprintf("%s throughput %f objs/sec is below -sla-min-throughput %f", r.Type, r.ObjsPerSec, s.minThroughput))
+	}
+	return v
+}
+
+// uploadFunc runs an operation on a single object, usually an upload,
+// and returns the number of object bytes transferred.
+type uploadFunc func(objectName string) (int64, error)
+
+// rateLimiter spaces operations out to a target rate, operations beyond
+// it are not made up for later. A nil rateLimiter does not limit.
+type rateLimiter struct {
+	ticker *time.Ticker
+	rate   float64
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{
+		ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond)),
+		rate:   perSecond,
+	}
+}
+
+// wait blocks until the next operation may start, it returns false when
+// ctx is done first.
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case <-l.ticker.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Uploads all the inputs objects in parallel and returns what was
+// measured, failed uploads are recorded in the errors of the phase and
+// the remaining uploads are carried on. At most workers uploads run at
+// the same time, all of them are started at once when workers is 0. No
+// new uploads are started once ctx is done, nor faster than limit
+// allows. When failFast is set this function exits upon the first error
+// instead.
+func parallelUploads(ctx context.Context, objectNames []string, workers int, upload uploadFunc, limit *rateLimiter, failFast bool) phase {
+	p := phase{concurrency: workers, lat: &latencies{}, start: time.Now().UTC()}
 	var wg sync.WaitGroup
+	// Every upload takes the slot of a worker and accounts for it in the
+	// stats of that worker, which no other upload touches meanwhile.
+	var slots chan int
+	if workers > 0 {
+		slots = make(chan int, workers)
+		for w := 0; w < workers; w++ {
+			slots <- w
+		}
+		p.workers = make([]workerStats, workers)
+	}
+	var uploaded, uploadedBytes int64
+	errCh := make(chan error, len(objectNames))
+loop:
 	for _, objectName := range objectNames {
+		slot := -1
+		if slots != nil {
+			select {
+			case slot = <-slots:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		if ctx.Err() != nil || !limit.wait(ctx) {
+			break
+		}
+		wg.Add(1)
+		go func(objectName string, slot int) {
+			defer wg.Done()
+			uploadStart := time.Now()
+			if slot >= 0 {
+				defer func() {
+					p.workers[slot].objects++
+					p.workers[slot].busy += time.Since(uploadStart)
+					slots <- slot
+				}()
+			}
+			n, err := upload(objectName)
+			if isRefused(err) {
+				return
+			}
+			if err != nil {
+				if failFast {
+					fatalf("%s: %v", objectName, err)
+				}
+				errCh <- fmt.Errorf("%s: %w", objectName, err)
+				return
+			}
+			p.lat.add(time.Since(uploadStart))
+			atomic.AddInt64(&uploaded, 1)
+			atomic.AddInt64(&uploadedBytes, n)
+		}(objectName, slot)
+	}
+	wg.Wait()
+	close(errCh)
+
+	p.elapsed = time.Since(p.start)
+	p.count, p.bytes = int(uploaded), uploadedBytes
+	for err := range errCh {
+		p.errs = append(p.errs, err)
+	}
+	return p
+}
+
+// Uploads objects with a pool of workers until the duration passed or
+// ctx is done, the uploads still in flight at that moment are finished
+// and counted. Returns what was measured, failed uploads are recorded in
+// the errors of the phase. When failFast is set this function exits
+// upon the first error instead.
+func timedUploads(ctx context.Context, names *nameSequence, workers int, duration time.Duration, upload uploadFunc, limit *rateLimiter, failFast bool) phase {
+	p := phase{concurrency: workers, lat: &latencies{}, start: time.Now().UTC(), workers: make([]workerStats, workers)}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var uploaded, uploadedBytes int64
+	deadline := time.Now().Add(duration)
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
-		go func(objectName string) {
+		go func(stats *workerStats) {
 			defer wg.Done()
-			if err := uploadBlob(data, objectName, metaCount, metaSize); err != nil {
-				panic(err)
+			for time.Now().Before(deadline) && ctx.Err() == nil {
+				if !limit.wait(ctx) || !time.Now().Before(deadline) {
+					break
+				}
+				name := names.next()
+				uploadStart := time.Now()
+				n, err := upload(name)
+				stats.objects++
+				stats.busy += time.Since(uploadStart)
+				if isRefused(err) {
+					continue
+				}
+				if err != nil {
+					if failFast {
+						fatalf("%s: %v", name, err)
+					}
+					mu.Lock()
+					p.errs = append(p.errs, fmt.Errorf("%s: %w", name, err))
+					mu.Unlock()
+					continue
+				}
+				p.lat.add(time.Since(uploadStart))
+				atomic.AddInt64(&uploaded, 1)
+				atomic.AddInt64(&uploadedBytes, n)
 			}
-		}(objectName)
+		}(&p.workers[w])
 	}
 	wg.Wait()
+	p.elapsed = time.Since(p.start)
+	p.count, p.bytes = int(uploaded), uploadedBytes
+	return p
 }
 
-// uploadBlob does an upload to the S3/Minio server
-func uploadBlob(data []byte, objectName string, metaCount int, metaSize int) error {
-	credsUp := credentials.NewStaticCredentials(os.Getenv("ACCESSKEY"), os.Getenv("SECRETKEY"), "")
-	sessUp := session.New(aws.NewConfig().
-		WithCredentials(credsUp).
-		WithRegion("us-east-1").
-		WithEndpoint(os.Getenv("ENDPOINT")).
-		WithS3ForcePathStyle(true))
+// Modes of the worker ramp.
+var rampModes = []string{"linear", "exponential"}
 
-	uploader := s3manager.NewUploader(sessUp, func(u *s3manager.Uploader) {
-		u.PartSize = 64 * 1024 * 1024 // 64MB per part
-	})
+// rampSchedule describes how the workers of a ramp grow, from start by
+// step in linear mode or by factor in exponential mode, until max.
+type rampSchedule struct {
+	start    int
+	step     int
+	factor   float64
+	max      int
+	interval time.Duration
 
-	meta := map[string]*string{}
-	var metadataValue string = randStringBytes(metaSize)
-	var key string
-	for i := 1; i <= metaCount; i++ {
-		key = fmt.Sprintf("%s-%v", "test-metadata-key", i)
-		meta[key] = &metadataValue
+	// Stop the ramp once a step failed more than this share of its
+	// uploads, 0 disables the check.
+	errorThreshold float64
+}
+
+// next returns the number of workers of the step after the one with w
+// workers, capped at max.
+func (s rampSchedule) next(w int) int {
+	n := w + s.step
+	if s.factor > 0 {
+		n = int(math.Ceil(float64(w) * s.factor))
 	}
-	var err error
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Body:   bytes.NewReader(data),
-		Bucket: aws.String(os.Getenv("BUCKET")),
-		Key:    aws.String(objectName),
-		Metadata: meta,
-	})
+	if n > s.max {
+		n = s.max
+	}
+	return n
+}
 
-	return err
+// Uploads objects with the start workers of the schedule for its
+// interval, then grows the workers after every interval until max
+// workers uploaded for an interval or a step failed more than the error
+// threshold. Each step is passed to report once it is done, returns the
+// number of uploaded objects and the errors of the uploads that failed.
+func rampUploads(ctx context.Context, names *nameSequence, schedule rampSchedule, upload uploadFunc, limit *rateLimiter, failFast bool, report func(phase)) (int, []error) {
+	var count int
+	var errs []error
+	w := schedule.start
+	if w > schedule.max {
+		w = schedule.max
+	}
+	for ctx.Err() == nil {
+		p := timedUploads(ctx, names, w, schedule.interval, upload, limit, failFast)
+		report(p)
+		count += p.count
+		errs = append(errs, p.errs...)
+		if rate := p.errorRate(); schedule.errorThreshold > 0 && rate > schedule.errorThreshold {
+			warnf("Stopping the ramp at %d workers, %.1f%% of the uploads failed", w, 100*rate)
+			break
+		}
+		if w == schedule.max {
+			break
+		}
+		w = schedule.next(w)
+	}
+	return count, errs
 }
 
-var (
-	objectSize = flag.Int("size", defaultObjectSize, "Size of the object to upload.")
-	metaCount = flag.Int("meta-count", defaultMetaCount, "Metadata entry count of the object to upload.")
-	metaSize = flag.Int("meta-size", defaultMetaSize, "Metadata size of each entry of the object to upload.")
-)
+// autotuneSettings bounds the search of autotuneUploads.
+type autotuneSettings struct {
+	max   int
+	probe time.Duration
 
-func main() {
-	flag.Parse()
+	// Smallest share by which the throughput has to grow for more
+	// workers to be worth it.
+	gain float64
 
-	concurrency := os.Getenv("CONCURRENCY")
-	nodeNumber := os.Getenv("NODE")
-	conc, err := strconv.Atoi(concurrency)
-	if err != nil {
-		log.Fatalln(err)
+	// Probes with a higher p99 latency are past the knee, 0 disables the
+	// check.
+	p99 time.Duration
+}
+
+// throughput returns the objects per second of the phase.
+func (p phase) throughput() float64 {
+	if p.elapsed <= 0 {
+		return 0
+	}
+	return float64(p.count) / p.elapsed.Seconds()
+}
+
+// Searches the number of workers reaching the peak throughput with
+// probes uploading for the probe duration of settings. The workers
+// double from 1 as long as the throughput grows by more than the gain and
+// the p99 latency stays below the knee, then the smallest number of
+// workers between the last two climbing probes reaching the peak
+// throughput, less the gain, is searched by bisection. Each probe is
+// passed to report, returns the best probe, the number of uploaded
+// objects and the errors of the uploads that failed.
+func autotuneUploads(ctx context.Context, names *nameSequence, settings autotuneSettings, upload uploadFunc, limit *rateLimiter, failFast bool, report func(phase)) (phase, int, []error) {
+	var count int
+	var errs []error
+	probe := func(w int) (phase, bool) {
+		p := timedUploads(ctx, names, w, settings.probe, upload, limit, failFast)
+		report(p)
+		count += p.count
+		errs = append(errs, p.errs...)
+		withinKnee := settings.p99 <= 0 || time.Duration(p.lat.stats().P99Ms*float64(time.Millisecond)) <= settings.p99
+		debugf("Probed %d workers, %f objs/sec", w, p.throughput())
+		return p, withinKnee && p.count > 0
+	}
+
+	best, _ := probe(1)
+	lo := 0
+	for best.concurrency < settings.max && ctx.Err() == nil {
+		w := 2 * best.concurrency
+		if w > settings.max {
+			w = settings.max
+		}
+		p, ok := probe(w)
+		if !ok || p.throughput() <= best.throughput()*(1+settings.gain) {
+			break
+		}
+		lo, best = best.concurrency, p
+	}
+
+	// Fewer workers than the peak may already reach the plateau, stop
+	// once the range is within a tenth of the workers.
+	peak := best.throughput()
+	hi := best.concurrency
+	for hi-lo > 1 && hi-lo > hi/10 && ctx.Err() == nil {
+		w := (lo + hi) / 2
+		if p, ok := probe(w); ok && p.throughput() >= peak*(1-settings.gain) {
+			best, hi = p, w
+		} else {
+			lo = w
+		}
+	}
+	return best, count, errs
+}
+
+// meanStddev returns the mean and the sample standard deviation of
+// values.
+func meanStddev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)-1))
+}
+
+// parseWarmup parses the -warmup flag, either a number of uploads or a
+// duration to keep uploading for.
+func parseWarmup(s string) (int, time.Duration, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return n, 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return 0, d, nil
 	}
+	return 0, 0, fmt.Errorf("invalid warmup %q, expected a number of uploads or a duration", s)
+}
 
-	var objectNames []string
-	for i := 0; i < conc; i++ {
-		objectNames = append(objectNames, fmt.Sprintf("object-%s-%d", nodeNumber, i+1))
+// parseMix parses a put:get ratio such as 70:30.
+func parseMix(s string) (put, get int, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid mix %q, expected put:get", s)
+	}
+	if put, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("invalid mix %q, %v", s, err)
+	}
+	if get, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("invalid mix %q, %v", s, err)
+	}
+	if put < 0 || get < 0 || put+get == 0 {
+		return 0, 0, fmt.Errorf("invalid mix %q, expected non negative weights", s)
 	}
+	return put, get, nil
+}
+
+// isPutJob reports whether the i-th job of a put:get mix is an upload,
+// the uploads are spread evenly over the jobs so that any run of jobs
+// follows the ratio as closely as possible.
+func isPutJob(i, put, get int) bool {
+	total := put + get
+	return (i+1)*put/total > i*put/total
+}
+
+// Runs jobs jobs with a pool of workers, or when duration is positive
+// keeps running jobs until it passed. The jobs are split between uploads
+// of new objects and downloads of the seeded objects according to the
+// put:get ratio. Returns the upload and the download phases.
+func mixedOps(ctx context.Context, names *nameSequence, seeded []string, workers, jobs int, duration time.Duration, put, get int, upload, download uploadFunc, limit *rateLimiter, failFast bool) (phase, phase) {
+	puts := phase{op: "PUT", concurrency: workers, lat: &latencies{}, workers: make([]workerStats, workers)}
+	gets := phase{op: "GET", concurrency: workers, lat: &latencies{}, workers: make([]workerStats, workers)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var putCount, getCount, putBytes, getBytes int64
 
-	var data = bytes.Repeat([]byte("a"), *objectSize)
+	jobCh := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range jobCh {
+				p, op, counter, byteCounter := &puts, upload, &putCount, &putBytes
+				var name string
+				if isPutJob(i, put, get) {
+					name = names.next()
+				} else {
+					// Only downloads need seeded objects, there may be
+					// none without downloads.
+					p, op, counter, byteCounter = &gets, download, &getCount, &getBytes
+					name = seeded[i%len(seeded)]
+				}
+				opStart := time.Now()
+				n, err := op(name)
+				p.workers[w].objects++
+				p.workers[w].busy += time.Since(opStart)
+				if isRefused(err) {
+					continue
+				}
+				if err != nil {
+					if failFast {
+						fatalf("%s: %v", name, err)
+					}
+					mu.Lock()
+					p.errs = append(p.errs, fmt.Errorf("%s: %w", name, err))
+					mu.Unlock()
+					continue
+				}
+				p.lat.add(time.Since(opStart))
+				atomic.AddInt64(counter, 1)
+				atomic.AddInt64(byteCounter, n)
+			}
+		}(w)
+	}
 
 	start := time.Now().UTC()
-	parallelUploads(objectNames, data, *metaCount, *metaSize)
+	deadline := start.Add(duration)
+	for i := 0; ctx.Err() == nil; i++ {
+		if duration > 0 && !time.Now().Before(deadline) {
+			break
+		}
+		if duration <= 0 && i == jobs {
+			break
+		}
+		if !limit.wait(ctx) {
+			break
+		}
+		jobCh <- i
+	}
+	close(jobCh)
+	wg.Wait()
 
-	totalSize := conc * *objectSize
 	elapsed := time.Since(start)
-	seconds := float64(elapsed) / float64(time.Second)
-	//fmt.Println("Type;Node Number;Concurrency;Object Size (bytes);Metadata Entries;Metadata Size (bytes);Elapsed Time;Speed (objs/sec);Bandwidth (MBit/sec);Start Timestamp;End Timestamp")
-	fmt.Printf("PUT;%s;%s;%d;%d;%d;%s;%f;%f;%s;%s\n", nodeNumber, concurrency, *objectSize, *metaCount, *metaSize, elapsed, float64(conc)/seconds, float64(totalSize)/seconds/1024/1024, start.Format("2006-01-02T15:04:05.000Z"), time.Now().Format("2006-01-02T15:04:05.000Z"))
-}
\ No newline at end of file
+	puts.start, puts.elapsed, puts.count, puts.bytes = start, elapsed, int(putCount), putBytes
+	gets.start, gets.elapsed, gets.count, gets.bytes = start, elapsed, int(getCount), getBytes
+	return puts, gets
+}
+
+// buckets holds the buckets given with -buckets, the BUCKET environment
+// variable is used when it is empty.
+var buckets []string
+
+// resolveBuckets returns the comma separated buckets given with -buckets,
+// falling back to the BUCKET environment variable.
+func resolveBuckets() []string {
+	list := *bucketList
+	if list == "" {
+		list = os.Getenv("BUCKET")
+	}
+	var resolved []string
+	for _, bucket := range strings.Split(list, ",") {
+		if bucket = strings.TrimSpace(bucket); bucket != "" {
+			resolved = append(resolved, bucket)
+		}
+	}
+	return resolved
+}
+
+// allBuckets returns every bucket objects are spread over.
+func allBuckets() []string {
+	if len(buckets) == 0 {
+		return []string{os.Getenv("BUCKET")}
+	}
+	return buckets
+}
+
+// bucketFor returns the bucket of objectName, picked by a hash of the
+// name so that every operation and every later run finds the object in
+// the same bucket.
+func bucketFor(objectName string) string {
+	all := allBuckets()
+	if len(all) == 1 {
+		return all[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(objectName))
+	return all[h.Sum32()%uint32(len(all))]
+}
+
+// groupByBucket splits objectNames by their bucket, in the order of
+// allBuckets.
+func groupByBucket(objectNames []string) [][]string {
+	byBucket := map[string][]string{}
+	for _, name := range objectNames {
+		bucket := bucketFor(name)
+		byBucket[bucket] = append(byBucket[bucket], name)
+	}
+	var groups [][]string
+	for _, bucket := range allBuckets() {
+		if len(byBucket[bucket]) > 0 {
+			groups = append(groups, byBucket[bucket])
+		}
+	}
+	return groups
+}
+
+// copyName returns the name of the server side copy of an object.
+func copyName(objectName string) string {
+	return objectName + "-copy"
+}
+
+// copyBlob copies an object on the S3/Minio server, the data does not
+// pass through the client.
+func copyBlob(ctx context.Context, svc s3iface.S3API, objectName string) error {
+	target := copyName(objectName)
+	_, err := svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(bucketFor(target)),
+		Key:        aws.String(target),
+		CopySource: aws.String(bucketFor(objectName) + "/" + url.PathEscape(objectName)),
+	})
+	return err
+}
+
+// downloadBlob does a download from the S3/Minio server, the data is
+// discarded. Returns the number of bytes downloaded.
+func downloadBlob(ctx context.Context, svc s3iface.S3API, objectName string) (int64, error) {
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketFor(objectName)),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+	return io.Copy(ioutil.Discard, out.Body)
+}
+
+// abortIncompleteUploads aborts the multipart uploads under prefix in
+// every bucket that were initiated before the given time. Returns the
+// number of aborted uploads.
+func abortIncompleteUploads(ctx context.Context, svc s3iface.S3API, buckets []string, prefix string, before time.Time) (int, error) {
+	aborted := 0
+	for _, bucket := range buckets {
+		var uploads []*s3.MultipartUpload
+		err := svc.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+			uploads = append(uploads, page.Uploads...)
+			return true
+		})
+		if err != nil {
+			return aborted, err
+		}
+		for _, upload := range uploads {
+			if !aws.TimeValue(upload.Initiated).Before(before) {
+				continue
+			}
+			if _, err := svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			}); err != nil {
+				return aborted, err
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
+}
+
+// deleteObjects removes the objects with DeleteObjects calls of at most
+// maxDeleteBatch keys. Returns the number of deleted objects.
+func deleteObjects(ctx context.Context, svc s3iface.S3API, objectNames []string) (int, error) {
+	deleted := 0
+	for _, group := range groupByBucket(objectNames) {
+		bucket := bucketFor(group[0])
+		for len(group) > 0 {
+			batch := group
+			if len(batch) > maxDeleteBatch {
+				batch = batch[:maxDeleteBatch]
+			}
+			group = group[len(batch):]
+
+			ids := make([]*s3.ObjectIdentifier, len(batch))
+			for i, name := range batch {
+				ids[i] = &s3.ObjectIdentifier{Key: aws.String(name)}
+			}
+			out, err := svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(bucket),
+				Delete: &s3.Delete{Objects: ids, Quiet: aws.Bool(false)},
+			})
+			if err != nil {
+				return deleted, err
+			}
+			deleted += len(out.Deleted)
+			if len(out.Errors) > 0 {
+				e := out.Errors[0]
+				return deleted, fmt.Errorf("deleting %s failed: %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// isNotFound reports whether err means that the object does not exist.
+func isNotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound"
+	}
+	return false
+}
+
+// listStats counts what a bucket listing returned.
+type listStats struct {
+	Keys  int `json:"keys"`
+	Pages int `json:"pages"`
+}
+
+// listObjects walks the listing of the objects under prefix in every
+// bucket with pages of at most pageSize keys, stopping once maxKeys keys
+// were listed when maxKeys is positive. The latency of every page is
+// recorded.
+func listObjects(ctx context.Context, svc s3iface.S3API, prefix string, pageSize, maxKeys int, lat *latencies) (listStats, error) {
+	var stats listStats
+	for _, bucket := range allBuckets() {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		}
+		if pageSize > 0 {
+			input.MaxKeys = aws.Int64(int64(pageSize))
+		}
+		for {
+			pageStart := time.Now()
+			out, err := svc.ListObjectsV2WithContext(ctx, input)
+			if err != nil {
+				return stats, err
+			}
+			lat.add(time.Since(pageStart))
+			stats.Pages++
+			stats.Keys += len(out.Contents)
+			if maxKeys > 0 && stats.Keys >= maxKeys {
+				return stats, nil
+			}
+			if !aws.BoolValue(out.IsTruncated) {
+				break
+			}
+			input.ContinuationToken = out.NextContinuationToken
+		}
+	}
+	return stats, nil
+}
+
+// Deletes the objects in parallel, one DeleteObject call per object when
+// batchSize is at most 1 or otherwise DeleteObjects calls of batchSize
+// keys. At most workers calls run at the same time, all of them when
+// workers is 0. Returns the number of deleted objects, the number of
+// objects that did not exist and the errors of the failed deletes.
+// Note that S3 and Minio report success when deleting a missing object,
+// only backends answering with NoSuchKey are counted as not found.
+func parallelDeletes(ctx context.Context, svc s3iface.S3API, objectNames []string, batchSize, workers int, lat *latencies, failFast bool) (int, int, []error) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	// A batch only holds keys of one bucket.
+	var batches [][]string
+	for _, group := range groupByBucket(objectNames) {
+		for len(group) > 0 {
+			n := batchSize
+			if n > len(group) {
+				n = len(group)
+			}
+			batches = append(batches, group[:n])
+			group = group[n:]
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sem chan struct{}
+	if workers > 0 {
+		sem = make(chan struct{}, workers)
+	}
+	var deleted, notFound int64
+	var errs []error
+	fail := func(err error) {
+		if failFast {
+			fatalf("%v", err)
+		}
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			break
+		}
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			deleteStart := time.Now()
+			if batchSize == 1 {
+				_, err := svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+					Bucket: aws.String(bucketFor(batch[0])),
+					Key:    aws.String(batch[0]),
+				})
+				switch {
+				case isNotFound(err):
+					atomic.AddInt64(&notFound, 1)
+				case err != nil:
+					fail(fmt.Errorf("%s: %v", batch[0], err))
+					return
+				default:
+					atomic.AddInt64(&deleted, 1)
+				}
+				lat.add(time.Since(deleteStart))
+				return
+			}
+
+			ids := make([]*s3.ObjectIdentifier, len(batch))
+			for i, name := range batch {
+				ids[i] = &s3.ObjectIdentifier{Key: aws.String(name)}
+			}
+			out, err := svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(bucketFor(batch[0])),
+				Delete: &s3.Delete{Objects: ids},
+			})
+			if err != nil {
+				fail(fmt.Errorf("%s to %s: %v", batch[0], batch[len(batch)-1], err))
+				return
+			}
+			lat.add(time.Since(deleteStart))
+			atomic.AddInt64(&deleted, int64(len(out.Deleted)))
+			for _, e := range out.Errors {
+				if aws.StringValue(e.Code) == s3.ErrCodeNoSuchKey {
+					atomic.AddInt64(&notFound, 1)
+					continue
+				}
+				fail(fmt.Errorf("%s: %s", aws.StringValue(e.Key), aws.StringValue(e.Message)))
+			}
+		}(batch)
+	}
+	wg.Wait()
+	return int(deleted), int(notFound), errs
+}
+
+// uploadMetrics are the Prometheus metrics of the uploads, served on
+// -metrics-addr.
+type uploadMetrics struct {
+	registry *prometheus.Registry
+	uploads  prometheus.Counter
+	errors   prometheus.Counter
+	bytes    prometheus.Counter
+	latency  prometheus.Histogram
+}
+
+func newUploadMetrics() *uploadMetrics {
+	m := &uploadMetrics{
+		registry: prometheus.NewRegistry(),
+		uploads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "uploads_total",
+			Help: "Number of successful uploads.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "upload_errors_total",
+			Help: "Number of failed uploads.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bytes_uploaded_total",
+			Help: "Number of object bytes uploaded.",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "upload_latency_seconds",
+			Help:    "Latency of the successful uploads.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}),
+	}
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "perftest_build_info",
+		Help:        "Build of the tool, always 1.",
+		ConstLabels: prometheus.Labels{"version": version, "commit": commit, "build_date": buildDate},
+	})
+	info.Set(1)
+	m.registry.MustRegister(m.uploads, m.errors, m.bytes, m.latency, info)
+	return m
+}
+
+// observe records the outcome of an upload.
+func (m *uploadMetrics) observe(n int64, d time.Duration, err error) {
+	if err != nil {
+		m.errors.Inc()
+		return
+	}
+	m.uploads.Inc()
+	m.bytes.Add(float64(n))
+	m.latency.Observe(d.Seconds())
+}
+
+// serve starts serving the metrics on /metrics at addr, the returned
+// server is shut down by the caller.
+func (m *uploadMetrics) serve(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			errorf("Metrics server failed: %v", err)
+		}
+	}()
+	return srv, nil
+}
+
+// progress holds running totals of the uploads, it is updated by every
+// worker with atomic operations.
+type progress struct {
+	objects int64
+	bytes   int64
+	errors  int64
+}
+
+// observe records the outcome of an upload.
+func (p *progress) observe(n int64, err error) {
+	if err != nil {
+		atomic.AddInt64(&p.errors, 1)
+		return
+	}
+	atomic.AddInt64(&p.objects, 1)
+	atomic.AddInt64(&p.bytes, n)
+}
+
+// report logs the running totals every interval until done is closed.
+func (p *progress) report(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastObjects int64
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			objects := atomic.LoadInt64(&p.objects)
+			rate := float64(objects-lastObjects) / interval.Seconds()
+			lastObjects = objects
+			infof("Progress: %d objects, %.1f objs/sec, %d bytes uploaded, %d errors", objects, rate, atomic.LoadInt64(&p.bytes), atomic.LoadInt64(&p.errors))
+		}
+	}
+}
+
+// handleSignals stops launching new uploads upon the first SIGINT or
+// SIGTERM, a second signal aborts the uploads still in flight.
+func handleSignals(stop, abort context.CancelFunc) {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		infof("Received %s, waiting for the uploads in flight, signal again to abort them", sig)
+		stop()
+		<-sigCh
+		infof("Aborting the uploads in flight")
+		abort()
+	}()
+}
+
+// loadPayload reads the payload file, when size is positive its contents
+// are repeated or truncated to exactly size bytes.
+func loadPayload(path string, size int) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 || len(b) == size {
+		return b, nil
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("payload file %s is empty", path)
+	}
+	data := make([]byte, size)
+	for n := 0; n < size; {
+		n += copy(data[n:], b)
+	}
+	return data, nil
+}
+
+// payloadFile is a file of -payload-dir, uploaded as is.
+type payloadFile struct {
+	name        string
+	data        []byte
+	contentType string
+	weight      int
+}
+
+// payloadSet picks the file uploaded as every object, round-robin or
+// drawn by weight when weights were given.
+type payloadSet struct {
+	files []payloadFile
+
+	// Sum of the weights, the files are used round-robin when 0.
+	total int
+	next  uint64
+}
+
+// loadPayloadDir reads the regular files of dir, their content type is
+// guessed from the extension. weights is a list of name=weight, files not
+// listed have a weight of 1.
+func loadPayloadDir(dir, weights string) (*payloadSet, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	set := &payloadSet{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(entry.Name()))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		set.files = append(set.files, payloadFile{name: entry.Name(), data: data, contentType: contentType, weight: 1})
+	}
+	if len(set.files) == 0 {
+		return nil, fmt.Errorf("payload directory %s has no files", dir)
+	}
+	if weights == "" {
+		return set, nil
+	}
+	for _, pair := range strings.Split(weights, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid payload weight %q, expected NAME=WEIGHT", pair)
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid payload weight %q, expected a non negative integer", pair)
+		}
+		found := false
+		for i := range set.files {
+			if set.files[i].name == kv[0] {
+				set.files[i].weight, found = weight, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("payload weight given for %s, which is not in %s", kv[0], dir)
+		}
+	}
+	for _, f := range set.files {
+		set.total += f.weight
+	}
+	if set.total == 0 {
+		return nil, errors.New("all payload weights are 0")
+	}
+	return set, nil
+}
+
+// pick returns the file of the next object, weighted picks are drawn
+// from rng so that they only depend on the object name.
+func (s *payloadSet) pick(rng *rand.Rand) *payloadFile {
+	if s.total == 0 {
+		i := atomic.AddUint64(&s.next, 1) - 1
+		return &s.files[i%uint64(len(s.files))]
+	}
+	n := rng.Intn(s.total)
+	for i := range s.files {
+		if n < s.files[i].weight {
+			return &s.files[i]
+		}
+		n -= s.files[i].weight
+	}
+	return &s.files[len(s.files)-1]
+}
+
+// payloadTypeCounts counts the objects and bytes uploaded with a content
+// type of -payload-dir.
+type payloadTypeCounts struct {
+	objects int64
+	bytes   int64
+}
+
+// maxSize returns the size of the largest file.
+func (s *payloadSet) maxSize() int {
+	size := 0
+	for _, f := range s.files {
+		if len(f.data) > size {
+			size = len(f.data)
+		}
+	}
+	return size
+}
+
+// contentTypes returns the distinct content types of the files, sorted.
+func (s *payloadSet) contentTypes() []string {
+	seen := map[string]bool{}
+	var types []string
+	for _, f := range s.files {
+		if !seen[f.contentType] {
+			seen[f.contentType] = true
+			types = append(types, f.contentType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Smallest share of the payload filled by one goroutine of fillRandom.
+const minFillChunk = 1024 * 1024
+
+// fillRandom fills data with random bytes from crypto/rand, split over up
+// to workers goroutines so that large payloads do not hold up the start
+// of the run.
+func fillRandom(data []byte, workers int) error {
+	chunk := (len(data) + workers - 1) / workers
+	if chunk < minFillChunk {
+		chunk = minFillChunk
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, workers+1)
+	for off := 0; off < len(data); off += chunk {
+		end := off + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		wg.Add(1)
+		go func(part []byte) {
+			defer wg.Done()
+			if _, err := crand.Read(part); err != nil {
+				errs <- err
+			}
+		}(data[off:end])
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// parseHumanNumber parses a size such as 100, 1MB or 10KiB into bytes.
+func parseHumanNumber(s string) (int64, error) {
+	multiplier := []int64{
+		1000,
+		1000 * 1000,
+		1000 * 1000 * 1000,
+		1000 * 1000 * 1000 * 1000,
+		1024,
+		1024 * 1024,
+		1024 * 1024 * 1024,
+		1024 * 1024 * 1024 * 1024,
+	}
+	suffixes := []string{
+		"KB", "MB", "GB", "TB",
+		"KiB", "MiB", "GiB", "TiB",
+	}
+	badSizeErr := fmt.Errorf("invalid size number %q given", s)
+	for i, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			v := strings.TrimSuffix(s, suffix)
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, badSizeErr
+			}
+			if n > math.MaxInt64/multiplier[i] || n < math.MinInt64/multiplier[i] {
+				return 0, fmt.Errorf("size %q overflows 64 bits", s)
+			}
+			return n * multiplier[i], nil
+		}
+	}
+	// try to parse raw byte number
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, badSizeErr
+	}
+	return n, nil
+}
+
+// byteSize is an int64 flag accepting the sizes of parseHumanNumber, such
+// as 10MB or 1GiB.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	n, err := parseHumanNumber(s)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("size %q must not be negative", s)
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// sizeFlag defines an int64 flag holding a size in bytes, given in bytes
+// or with a unit.
+func sizeFlag(name string, value int64, usage string) *int64 {
+	p := new(int64)
+	*p = value
+	flag.Var((*byteSize)(p), name, usage)
+	return p
+}
+
+// formatSize returns n in the largest unit dividing it, decimal units
+// first, for example 10MiB for 10485760 and 512KB for 512000.
+func formatSize(n int64) string {
+	if n > 0 {
+		for i := 3; i >= 0; i-- {
+			for _, u := range []struct {
+				base   int64
+				suffix string
+			}{{1000, "B"}, {1024, "iB"}} {
+				unit := int64(1)
+				for j := 0; j <= i; j++ {
+					unit *= u.base
+				}
+				if n%unit == 0 {
+					return fmt.Sprintf("%d%c%s", n/unit, "KMGT"[i], u.suffix)
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// Upper bound of a lognormal size distribution, as a multiple of the
+// mean, when no max is given.
+const defaultLognormalMaxFactor = 100
+
+// sizeDistribution draws object sizes, uniformly between min and max or
+// lognormally around mean and clipped at max.
+type sizeDistribution struct {
+	lognormal bool
+	min, max  int64
+	// Parameters of the underlying normal distribution when lognormal.
+	logMean, sigma float64
+}
+
+// parseSizeDistribution parses uniform:MIN-MAX or
+// lognormal:mean=MEAN,sigma=SIGMA[,max=MAX], sizes may have a KB, MiB,
+// ... suffix.
+func parseSizeDistribution(s string) (*sizeDistribution, error) {
+	kind, params := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, params = s[:i], s[i+1:]
+	}
+	d := &sizeDistribution{}
+	switch kind {
+	case "uniform":
+		bounds := strings.Split(params, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid uniform size distribution %q, expected uniform:MIN-MAX", s)
+		}
+		var err error
+		if d.min, err = parseHumanNumber(bounds[0]); err != nil {
+			return nil, err
+		}
+		if d.max, err = parseHumanNumber(bounds[1]); err != nil {
+			return nil, err
+		}
+		if d.min < 0 || d.max < d.min {
+			return nil, fmt.Errorf("invalid uniform size distribution %q, expected 0 <= MIN <= MAX", s)
+		}
+	case "lognormal":
+		d.lognormal = true
+		var mean int64
+		for _, param := range strings.Split(params, ",") {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid lognormal size distribution parameter %q", param)
+			}
+			var err error
+			switch kv[0] {
+			case "mean":
+				mean, err = parseHumanNumber(kv[1])
+			case "max":
+				d.max, err = parseHumanNumber(kv[1])
+			case "sigma":
+				d.sigma, err = strconv.ParseFloat(kv[1], 64)
+			default:
+				err = fmt.Errorf("unknown lognormal size distribution parameter %q", kv[0])
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if mean <= 0 || d.sigma < 0 {
+			return nil, fmt.Errorf("invalid lognormal size distribution %q, expected a positive mean and a sigma of at least 0", s)
+		}
+		if d.max == 0 {
+			d.max = defaultLognormalMaxFactor * mean
+		}
+		// Pick the normal distribution so that the lognormal one has
+		// the requested mean.
+		d.logMean = math.Log(float64(mean)) - d.sigma*d.sigma/2
+	default:
+		return nil, fmt.Errorf("unknown size distribution %q, expected uniform or lognormal", kind)
+	}
+	return d, nil
+}
+
+// parseSizeJitter parses a jitter such as 10% into a uniform
+// distribution within that band around size.
+func parseSizeJitter(s string, size int64) (*sizeDistribution, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || pct < 0 || pct >= 100 {
+		return nil, fmt.Errorf("invalid size jitter %q, expected a percentage such as 10%%, at least 0 and below 100", s)
+	}
+	band := int64(float64(size) * pct / 100)
+	return &sizeDistribution{min: size - band, max: size + band}, nil
+}
+
+// next draws the size of an object from rng.
+func (d *sizeDistribution) next(rng *rand.Rand) int64 {
+	if !d.lognormal {
+		return d.min + rng.Int63n(d.max-d.min+1)
+	}
+	size := int64(math.Exp(d.logMean + d.sigma*rng.NormFloat64()))
+	if size > d.max || size < 0 {
+		size = d.max
+	}
+	return size
+}
+
+// Interval in bytes at which uniqueReader stamps the payload.
+const stampInterval = 4096
+
+// uniqueReader reads a shared payload with the start of every
+// stampInterval bytes overwritten by the object name and the block
+// number. Every object, and every block of an object, then differs while
+// the payload itself is neither copied nor modified.
+type uniqueReader struct {
+	src  io.ReaderAt
+	size int64
+	name string
+	off  int64
+}
+
+func newUniqueReader(data []byte, name string) *uniqueReader {
+	return newUniqueReaderAt(bytes.NewReader(data), int64(len(data)), name)
+}
+
+// newUniqueReaderAt stamps the first size bytes of src.
+func newUniqueReaderAt(src io.ReaderAt, size int64, name string) *uniqueReader {
+	return &uniqueReader{src: src, size: size, name: name}
+}
+
+func (r *uniqueReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := p
+	if int64(len(want)) > r.size-off {
+		want = want[:r.size-off]
+	}
+	n, err := r.src.ReadAt(want, off)
+	if err != nil && (err != io.EOF || n < len(want)) {
+		return n, err
+	}
+	end := off + int64(n)
+	stamp := make([]byte, 0, len(r.name)+21)
+	for block := off / stampInterval; block*stampInterval < end; block++ {
+		stamp = strconv.AppendInt(append(append(stamp[:0], r.name...), ':'), block, 10)
+		blockStart := block * stampInterval
+		for i, b := range stamp {
+			if pos := blockStart + int64(i); pos >= off && pos < end {
+				p[pos-off] = b
+			}
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *uniqueReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *uniqueReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// streamBody is the body of a streamed upload. It reads at any offset so
+// that s3manager sends every part as a section of it, any other reader is
+// copied into part sized buffers first.
+type streamBody interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// newStreamBody returns the first size bytes of src as a body, stamped
+// with name when it is not empty.
+func newStreamBody(src io.ReaderAt, size int64, name string) streamBody {
+	if name != "" {
+		return newUniqueReaderAt(src, size, name)
+	}
+	return io.NewSectionReader(src, 0, size)
+}
+
+// generatedPayload generates size bytes on the fly, the character a or
+// pseudo random bytes derived from seed and the offset, so that objects
+// of any size are uploaded without being held in memory.
+type generatedPayload struct {
+	size   int64
+	random bool
+	seed   uint64
+}
+
+func (g generatedPayload) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= g.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > g.size-off {
+		n = int(g.size - off)
+	}
+	for i := 0; i < n; {
+		pos := off + int64(i)
+		if !g.random {
+			p[i] = 'a'
+			i++
+			continue
+		}
+		w := splitmix64(g.seed + uint64(pos/8))
+		for b := pos % 8; b < 8 && i < n; b++ {
+			p[i] = byte(w >> (8 * uint(b)))
+			i++
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// splitmix64 is the finalizer of the SplitMix64 generator, it turns a
+// counter into well mixed pseudo random bits.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Client side compressions of the payload.
+var compressions = []string{"none", "gzip"}
+
+// gzipWriters holds gzip writers for reuse, their compression state is
+// large compared to small objects.
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// gzipBody compresses the remaining data of body with gzip into memory,
+// so that the compressed body can be retried and its size is known.
+func gzipBody(body io.Reader) (*bytes.Reader, error) {
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(&buf)
+	if _, err := io.Copy(zw, body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// md5Hex returns the hex encoded MD5 of the remaining data of r and
+// seeks back to where it started.
+func md5Hex(r io.ReadSeeker) (string, error) {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	h := md5.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return "", err
+	}
+	if _, err = r.Seek(pos, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// objectName returns the name of the i-th object uploaded by a node.
+func objectName(nodeNumber string, i int) string {
+	return fmt.Sprintf("object-%s-%d", nodeNumber, i)
+}
+
+// Placeholders of a key template, {i} is required to keep keys unique.
+var keyPlaceholders = []string{"{node}", "{i}", "{rand}", "{ts}", "{prefix}"}
+
+// validateKeyTemplate checks that a key template has an {i} placeholder
+// and no unknown placeholders.
+func validateKeyTemplate(template string) error {
+	if !strings.Contains(template, "{i}") {
+		return fmt.Errorf("key template %q must contain {i}", template)
+	}
+	rest := template
+	for _, placeholder := range keyPlaceholders {
+		rest = strings.Replace(rest, placeholder, "", -1)
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("key template %q has an unknown placeholder, expected %s", template, strings.Join(keyPlaceholders, ", "))
+	}
+	return nil
+}
+
+// keyHash hashes the node and the number of an object.
+func keyHash(nodeNumber string, i int) uint32 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s-%d", nodeNumber, i)
+	return h.Sum32()
+}
+
+// keyPrefixIndex returns which of count prefixes the i-th object of a node
+// is put under.
+func keyPrefixIndex(nodeNumber string, i, count int) int {
+	return int(keyHash(nodeNumber, i) % uint32(count))
+}
+
+// keyPrefix returns the prefix of the i-th object of a node out of count,
+// p000/ to p063/ for 64 prefixes.
+func keyPrefix(nodeNumber string, i, count int) string {
+	width := len(strconv.Itoa(count - 1))
+	if width < 3 {
+		width = 3
+	}
+	return fmt.Sprintf("p%0*d/", width, keyPrefixIndex(nodeNumber, i, count))
+}
+
+// expandKeyTemplate returns the key of the i-th object of a node. {rand}
+// is a hash of the node and i, so that later runs find the same keys,
+// {ts} is the start of the run in Unix seconds and {prefix} is one of
+// prefixes prefixes, empty when prefixes is 0.
+func expandKeyTemplate(template, nodeNumber string, i int, ts int64, prefixes int) string {
+	prefix := ""
+	if prefixes > 0 {
+		prefix = keyPrefix(nodeNumber, i, prefixes)
+	}
+	return strings.NewReplacer(
+		"{node}", nodeNumber,
+		"{i}", strconv.Itoa(i),
+		"{rand}", fmt.Sprintf("%08x", keyHash(nodeNumber, i)),
+		"{ts}", strconv.FormatInt(ts, 10),
+		"{prefix}", prefix,
+	).Replace(template)
+}
+
+// nameSequence hands out the object names of a node in order, it is safe
+// for concurrent use. The names follow template when it is set and end
+// with suffix. With prefixes set they are spread over that many prefixes,
+// at the {prefix} placeholder or else in front of the name.
+type nameSequence struct {
+	nodeNumber string
+	template   string
+	suffix     string
+	ts         int64
+	prefixes   int
+	last       int64
+}
+
+func (s *nameSequence) name(i int) string {
+	if s.template == "" {
+		name := objectName(s.nodeNumber, i) + s.suffix
+		if s.prefixes > 0 {
+			name = keyPrefix(s.nodeNumber, i, s.prefixes) + name
+		}
+		return name
+	}
+	name := expandKeyTemplate(s.template, s.nodeNumber, i, s.ts, s.prefixes) + s.suffix
+	if s.prefixes > 0 && !strings.Contains(s.template, "{prefix}") {
+		name = keyPrefix(s.nodeNumber, i, s.prefixes) + name
+	}
+	return name
+}
+
+func (s *nameSequence) next() string {
+	return s.name(int(atomic.AddInt64(&s.last, 1)))
+}
+
+// prefixCounts adds the number of names handed out so far under every
+// prefix to counts, which holds one entry per prefix.
+func (s *nameSequence) prefixCounts(counts []int) {
+	last := int(atomic.LoadInt64(&s.last))
+	for i := 1; i <= last; i++ {
+		counts[keyPrefixIndex(s.nodeNumber, i, s.prefixes)]++
+	}
+}
+
+// logPrefixSpread logs how evenly the keys were spread over the prefixes,
+// counts holding the number of keys of every prefix.
+func logPrefixSpread(counts []int) {
+	total, min, max := 0, counts[0], counts[0]
+	for _, n := range counts {
+		total += n
+		if n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+	}
+	mean := float64(total) / float64(len(counts))
+	above := 0.0
+	if mean > 0 {
+		above = (float64(max)/mean - 1) * 100
+	}
+	infof("Spread %d keys over %d prefixes, %d to %d keys per prefix, the fullest %.1f%% above the mean", total, len(counts), min, max, above)
+}
+
+// take hands out the next n names, in a random order drawn from rng when
+// it is not nil.
+func (s *nameSequence) take(n int, rng *rand.Rand) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = s.next()
+	}
+	if rng != nil {
+		rng.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+	}
+	return names
+}
+
+// keyList collects the keys the run wrote, it is safe for concurrent
+// use. A nil keyList collects nothing.
+type keyList struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (l *keyList) add(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.keys = append(l.keys, key)
+	l.mu.Unlock()
+}
+
+// all returns every key added so far, in the order they were added.
+func (l *keyList) all() []string {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.keys...)
+}
+
+// countingRetryer is the default SDK retryer which counts the retried
+// requests.
+type countingRetryer struct {
+	client.DefaultRetryer
+	retries *int64
+}
+
+// RetryRules is only called by the SDK for requests that are retried.
+func (r countingRetryer) RetryRules(req *request.Request) time.Duration {
+	atomic.AddInt64(r.retries, 1)
+	return r.DefaultRetryer.RetryRules(req)
+}
+
+// sessionOptions holds the settings of the session shared by all uploads.
+type sessionOptions struct {
+	endpoint string
+	region   string
+
+	// Use the default AWS credential chain when ACCESSKEY and SECRETKEY
+	// are not set.
+	credsChain bool
+
+	// User-Agent header of every request, the SDK default when empty.
+	userAgent string
+
+	// Connection pool of the HTTP transport, the defaults of
+	// net/http are kept for zero values.
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+
+	// Time allowed to connect and to wait for the response headers once
+	// the request was sent, the defaults of net/http for zero values.
+	dialTimeout   time.Duration
+	headerTimeout time.Duration
+
+	// Counts the attempts failing on these timeouts, when set.
+	timeouts *transportTimeouts
+
+	// Address buckets in the path instead of in the host name.
+	pathStyle bool
+
+	// Skip the Content-MD5 the SDK computes for uploads and the MD5
+	// validation of downloads.
+	disableMD5 bool
+
+	// Use plain HTTP, or HTTPS without verifying the certificate.
+	disableSSL         bool
+	insecureSkipVerify bool
+
+	// Maximum number of retries of a failed request and the minimum
+	// delay before retrying, the delay grows exponentially.
+	retries      int
+	retryBackoff time.Duration
+
+	// Incremented for every retried request.
+	retried *int64
+
+	// Minimum delay before retrying a throttled request, the delay grows
+	// exponentially as for other failures.
+	throttleBackoff time.Duration
+
+	// Incremented for every attempt throttled by the backend, when set.
+	throttled *int64
+
+	// Incremented for every multipart upload aborted, when set.
+	aborted *int64
+
+	// How the body of uploads is signed, one of payloadSignings.
+	payloadSigning string
+}
+
+// newHTTPClient returns the HTTP client used by the session.
+func newHTTPClient(opts sessionOptions) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if opts.maxIdleConns > 0 {
+		transport.MaxIdleConns = opts.maxIdleConns
+	}
+	if opts.maxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = opts.maxIdleConnsPerHost
+	}
+	if opts.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = opts.idleConnTimeout
+	}
+	if opts.dialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   opts.dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if opts.headerTimeout > 0 {
+		transport.ResponseHeaderTimeout = opts.headerTimeout
+	}
+	return &http.Client{Transport: transport}
+}
+
+// transportTimeouts counts the request attempts that failed because the
+// connection could not be made or the response headers did not arrive in
+// time. Such attempts are retried like other connection errors.
+type transportTimeouts struct {
+	dial           int64
+	responseHeader int64
+}
+
+// observe counts the attempt of r when it failed on a transport timeout.
+func (t *transportTimeouts) observe(r *request.Request) {
+	switch transportTimeout(r.Error) {
+	case "dial":
+		atomic.AddInt64(&t.dial, 1)
+	case "response-header":
+		atomic.AddInt64(&t.responseHeader, 1)
+	}
+}
+
+// isThrottled reports whether the backend asked to slow down the attempt
+// of r, with a SlowDown error or a 503 Service Unavailable.
+func isThrottled(r *request.Request) bool {
+	if aerr, ok := r.Error.(awserr.Error); ok && aerr.Code() == "SlowDown" {
+		return true
+	}
+	return r.Error != nil && r.HTTPResponse != nil && r.HTTPResponse.StatusCode == http.StatusServiceUnavailable
+}
+
+// transportTimeout returns which transport timeout err, or an error it
+// wraps, is, either dial or response-header, and "" for other errors.
+func transportTimeout(err error) string {
+	for err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout() {
+			return "dial"
+		}
+		if strings.Contains(err.Error(), "timeout awaiting response headers") {
+			return "response-header"
+		}
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return ""
+		}
+		err = aerr.OrigErr()
+	}
+	return ""
+}
+
+// newUploader creates the uploader shared by all uploads, so that the
+// session and its HTTP connections are reused across objects.
+func newUploader(opts sessionOptions, partSize int64, partConcurrency int) *s3manager.Uploader {
+	config := aws.NewConfig().
+		WithRegion(opts.region).
+		WithEndpoint(opts.endpoint).
+		WithS3ForcePathStyle(opts.pathStyle).
+		WithDisableSSL(opts.disableSSL).
+		WithS3DisableContentMD5Validation(opts.disableMD5).
+		WithHTTPClient(newHTTPClient(opts)).
+		WithMaxRetries(opts.retries)
+	sharedConfig := session.SharedConfigStateFromEnv
+	accessKey, secretKey := os.Getenv("ACCESSKEY"), os.Getenv("SECRETKEY")
+	if !opts.credsChain || accessKey != "" || secretKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(accessKey, secretKey, ""))
+	} else {
+		// Without credentials the session falls back to the default
+		// chain of environment variables, shared profiles, web identity
+		// and instance roles.
+		sharedConfig = session.SharedConfigEnable
+	}
+	sessUp := session.Must(session.NewSessionWithOptions(session.Options{
+		Config: *request.WithRetryer(config, countingRetryer{
+			DefaultRetryer: client.DefaultRetryer{
+				NumMaxRetries:    opts.retries,
+				MinRetryDelay:    opts.retryBackoff,
+				MinThrottleDelay: opts.throttleBackoff,
+			},
+			retries: opts.retried,
+		}),
+		SharedConfigState: sharedConfig,
+	}))
+	if opts.userAgent != "" {
+		sessUp.Handlers.Build.PushBack(func(r *request.Request) {
+			r.HTTPRequest.Header.Set("User-Agent", opts.userAgent)
+		})
+	}
+	if opts.timeouts != nil {
+		sessUp.Handlers.CompleteAttempt.PushBack(opts.timeouts.observe)
+	}
+	if opts.throttled != nil {
+		sessUp.Handlers.CompleteAttempt.PushBack(func(r *request.Request) {
+			if isThrottled(r) {
+				atomic.AddInt64(opts.throttled, 1)
+			}
+		})
+	}
+	// The hash is set before the SDK hashes the body itself.
+	switch opts.payloadSigning {
+	case "unsigned":
+		sessUp.Handlers.Build.PushFront(setPayloadHash(unsignedPayload))
+	case "streaming":
+		sessUp.Handlers.Build.PushFront(setPayloadHash(streamingPayload))
+		sessUp.Handlers.Sign.PushFront(useChunkedBody)
+	}
+
+	if opts.aborted != nil {
+		sessUp.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.Operation.Name == "AbortMultipartUpload" && r.Error == nil {
+				atomic.AddInt64(opts.aborted, 1)
+			}
+		})
+	}
+
+	return s3manager.NewUploader(sessUp, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = partConcurrency
+		// Failed multipart uploads are aborted so that their parts do
+		// not pile up in the bucket.
+		u.LeavePartsOnError = false
+	})
+}
+
+// Payload signing modes, the SHA-256 of the whole body is signed, the body
+// is not signed at all, or it is signed chunk by chunk while it is sent.
+var payloadSignings = []string{"signed", "unsigned", "streaming"}
+
+// Payload hashes of unsigned and streaming signed uploads.
+const (
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+// Size of the chunks of streaming signed uploads.
+const streamingChunkSize = 64 * 1024
+
+// hasPayload reports whether r uploads object data.
+func hasPayload(r *request.Request) bool {
+	return r.Operation.Name == "PutObject" || r.Operation.Name == "UploadPart"
+}
+
+// setPayloadHash sets the payload hash uploads are signed with.
+func setPayloadHash(hash string) func(*request.Request) {
+	return func(r *request.Request) {
+		if hasPayload(r) {
+			r.HTTPRequest.Header.Set("X-Amz-Content-Sha256", hash)
+		}
+	}
+}
+
+// useChunkedBody replaces the body of an upload by its aws-chunked
+// encoding before the request is signed. Retries keep the encoded body,
+// it starts over when the SDK rewinds it.
+func useChunkedBody(r *request.Request) {
+	if r.Error != nil || !hasPayload(r) {
+		return
+	}
+	if _, ok := r.Body.(*chunkedBody); ok {
+		return
+	}
+	size, err := aws.SeekerLen(r.Body)
+	if err != nil {
+		r.Error = err
+		return
+	}
+	start, err := r.Body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		r.Error = err
+		return
+	}
+	header := r.HTTPRequest.Header
+	header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(size, 10))
+	if encoding := header.Get("Content-Encoding"); encoding != "" {
+		header.Set("Content-Encoding", "aws-chunked,"+encoding)
+	} else {
+		header.Set("Content-Encoding", "aws-chunked")
+	}
+	// Recomputed from the encoded body.
+	header.Del("Content-Length")
+	r.SetReaderBody(&chunkedBody{req: r, src: r.Body, srcStart: start, size: size})
+}
+
+// chunkedLength returns the length of the aws-chunked encoding of size
+// bytes, the last chunk is empty.
+func chunkedLength(size int64) int64 {
+	chunk := func(n int64) int64 {
+		return int64(len(strconv.FormatInt(n, 16))+len(";chunk-signature=")+64+2) + n + 2
+	}
+	length := size / streamingChunkSize * chunk(streamingChunkSize)
+	if rest := size % streamingChunkSize; rest > 0 {
+		length += chunk(rest)
+	}
+	return length + chunk(0)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// chunkedBody encodes the body of a request with aws-chunked, every chunk
+// is signed with the signature of the previous one. The first chunk is
+// chained to the signature of the request, which is only known once it
+// was signed, so it is read from the request when the body is first read.
+type chunkedBody struct {
+	req      *request.Request
+	src      io.ReadSeeker
+	srcStart int64
+	size     int64
+
+	key, date, scope string
+	signature        string
+
+	// Read bytes of the encoding and of src, the unread rest of the
+	// current chunk and whether the last chunk was encoded.
+	offset int64
+	read   int64
+	chunk  []byte
+	data   []byte
+	done   bool
+}
+
+// seed reads the signing key and the signature of the request the first
+// chunk is chained to.
+func (b *chunkedBody) seed() error {
+	auth := b.req.HTTPRequest.Header.Get("Authorization")
+	var credential string
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		if strings.HasPrefix(part, "Credential=") {
+			credential = strings.TrimPrefix(part, "Credential=")
+		} else if strings.HasPrefix(part, "Signature=") {
+			b.signature = strings.TrimPrefix(part, "Signature=")
+		}
+	}
+	scope := strings.SplitN(credential, "/", 2)
+	if len(scope) != 2 || b.signature == "" {
+		return errors.New("streaming signing needs a signed request")
+	}
+	b.scope = scope[1]
+	parts := strings.Split(b.scope, "/")
+	if len(parts) != 4 {
+		return fmt.Errorf("invalid credential scope %q", b.scope)
+	}
+	creds, err := b.req.Config.Credentials.Get()
+	if err != nil {
+		return err
+	}
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), parts[0])
+	for _, part := range parts[1:] {
+		key = hmacSHA256(key, part)
+	}
+	b.key, b.date = string(key), b.req.HTTPRequest.Header.Get("X-Amz-Date")
+	return nil
+}
+
+// next encodes the next chunk of src.
+func (b *chunkedBody) next() error {
+	if b.data == nil {
+		b.data = make([]byte, streamingChunkSize)
+	}
+	n := int64(streamingChunkSize)
+	if rest := b.size - b.read; rest < n {
+		n = rest
+	}
+	if _, err := io.ReadFull(b.src, b.data[:n]); err != nil {
+		return err
+	}
+	b.read += n
+	payloadHash := sha256.Sum256(b.data[:n])
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256-PAYLOAD",
+		b.date,
+		b.scope,
+		b.signature,
+		hex.EncodeToString(sha256.New().Sum(nil)),
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	b.signature = hex.EncodeToString(hmacSHA256([]byte(b.key), stringToSign))
+	b.chunk = append(b.chunk[:0], fmt.Sprintf("%x;chunk-signature=%s\r\n", n, b.signature)...)
+	b.chunk = append(b.chunk, b.data[:n]...)
+	b.chunk = append(b.chunk, "\r\n"...)
+	b.done = n == 0
+	return nil
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	if b.key == "" {
+		if err := b.seed(); err != nil {
+			return 0, err
+		}
+	}
+	for len(b.chunk) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if err := b.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.chunk)
+	b.chunk = b.chunk[n:]
+	b.offset += int64(n)
+	return n, nil
+}
+
+// Seek only supports what the SDK needs, rewinding the body and finding
+// its length.
+func (b *chunkedBody) Seek(offset int64, whence int) (int64, error) {
+	switch {
+	case offset == 0 && whence == io.SeekCurrent:
+		return b.offset, nil
+	case offset == 0 && whence == io.SeekStart:
+		if _, err := b.src.Seek(b.srcStart, io.SeekStart); err != nil {
+			return 0, err
+		}
+		// The request is signed again before it is retried.
+		b.key, b.offset, b.read, b.chunk, b.done = "", 0, 0, b.chunk[:0], false
+		return 0, nil
+	case offset == 0 && whence == io.SeekEnd:
+		b.offset, b.chunk, b.done = chunkedLength(b.size), b.chunk[:0], true
+		return b.offset, nil
+	}
+	return 0, fmt.Errorf("unsupported seek to %d from %d", offset, whence)
+}
+
+// endpointPool hands out an uploader per endpoint in round-robin order
+// and counts the objects uploaded to every endpoint. It is safe for
+// concurrent use.
+type endpointPool struct {
+	endpoints []string
+	uploaders map[string]*s3manager.Uploader
+	counts    map[string]*int64
+	next      uint64
+}
+
+func newEndpointPool(endpoints []string, opts sessionOptions, partSize int64, partConcurrency int) *endpointPool {
+	p := &endpointPool{
+		endpoints: endpoints,
+		uploaders: map[string]*s3manager.Uploader{},
+		counts:    map[string]*int64{},
+	}
+	for _, endpoint := range endpoints {
+		opts.endpoint = endpoint
+		p.uploaders[endpoint] = newUploader(opts, partSize, partConcurrency)
+		p.counts[endpoint] = new(int64)
+	}
+	return p
+}
+
+// pick returns the next endpoint and its uploader.
+func (p *endpointPool) pick() (string, *s3manager.Uploader) {
+	endpoint := p.endpoints[(atomic.AddUint64(&p.next, 1)-1)%uint64(len(p.endpoints))]
+	return endpoint, p.uploaders[endpoint]
+}
+
+// closeIdleConnections closes the idle connections of all uploaders.
+func (p *endpointPool) closeIdleConnections() {
+	for _, uploader := range p.uploaders {
+		if svc, ok := uploader.S3.(*s3.S3); ok {
+			svc.Client.Config.HTTPClient.CloseIdleConnections()
+		}
+	}
+}
+
+// renew closes the idle connections of the pool and returns a pool of
+// new uploaders for the same endpoints, which adds to the counts of p.
+func (p *endpointPool) renew(opts sessionOptions, partSize int64, partConcurrency int) *endpointPool {
+	p.closeIdleConnections()
+	fresh := newEndpointPool(p.endpoints, opts, partSize, partConcurrency)
+	fresh.counts = p.counts
+	return fresh
+}
+
+// uploaded counts an object uploaded to endpoint.
+func (p *endpointPool) uploaded(endpoint string) {
+	atomic.AddInt64(p.counts[endpoint], 1)
+}
+
+// resolveEndpoints returns the endpoints given with -endpoints, falling
+// back to the comma separated ENDPOINTS and then to the ENDPOINT
+// environment variable.
+func resolveEndpoints() []string {
+	list := *endpointList
+	if list == "" {
+		list = os.Getenv("ENDPOINTS")
+	}
+	if list == "" {
+		list = os.Getenv("ENDPOINT")
+	}
+	var endpoints []string
+	for _, endpoint := range strings.Split(list, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// objectOptions holds the settings applied to every uploaded object.
+type objectOptions struct {
+	metaCount   int
+	metaSize    int
+	metaCharset string
+
+	// Server side encryption, either empty, AES256 or aws:kms.
+	sse       string
+	sseKMSKey string
+
+	// Storage class, the backend default is used when empty.
+	storageClass string
+
+	// Canned ACL, such as private or public-read, not sent when empty.
+	acl string
+
+	// Content type, the SDK default is used when empty.
+	contentType string
+
+	// Content encoding of compressed bodies, none when empty.
+	contentEncoding string
+
+	// URL encoded tag set, see parseTags.
+	tagging string
+
+	// Checksum algorithm computed by the client, none when empty.
+	checksum string
+
+	// Only create objects whose key does not exist yet.
+	ifAbsent bool
+
+	// Object lock retention mode, GOVERNANCE or COMPLIANCE, and how long
+	// after the upload the objects are retained. No retention when empty.
+	lockMode   string
+	lockRetain time.Duration
+
+	// Object lock legal hold, ON or OFF, not sent when empty.
+	legalHold string
+}
+
+// parseRetention parses a retention period relative to the upload, such
+// as +1h or 1h.
+func parseRetention(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(s, "+"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q, expected a duration such as +1h", s)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q, expected a positive duration", s)
+	}
+	return d, nil
+}
+
+// Limits of S3 on the tags of an object.
+const (
+	maxTags           = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// parseTags parses k1=v1,k2=v2 into the URL encoded tag set sent with
+// an upload and returns it together with the number of tags.
+func parseTags(s string) (string, int, error) {
+	if s == "" {
+		return "", 0, nil
+	}
+	tags := url.Values{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", 0, fmt.Errorf("invalid tag %q, expected KEY=VALUE", pair)
+		}
+		if _, ok := tags[kv[0]]; ok {
+			return "", 0, fmt.Errorf("duplicate tag %q", kv[0])
+		}
+		if len(kv[0]) > maxTagKeyLength || len(kv[1]) > maxTagValueLength {
+			return "", 0, fmt.Errorf("tag %q exceeds %d bytes for the key or %d bytes for the value", kv[0], maxTagKeyLength, maxTagValueLength)
+		}
+		tags.Set(kv[0], kv[1])
+	}
+	if len(tags) > maxTags {
+		return "", 0, fmt.Errorf("%d tags given, at most %d are allowed", len(tags), maxTags)
+	}
+	return tags.Encode(), len(tags), nil
+}
+
+// validate checks the options before any upload is started.
+func (o objectOptions) validate() error {
+	switch o.sse {
+	case "", s3.ServerSideEncryptionAes256:
+		if o.sseKMSKey != "" {
+			return fmt.Errorf("-sse-kms-key requires -sse %s", s3.ServerSideEncryptionAwsKms)
+		}
+	case s3.ServerSideEncryptionAwsKms:
+		if o.sseKMSKey == "" {
+			return fmt.Errorf("-sse %s requires -sse-kms-key", s3.ServerSideEncryptionAwsKms)
+		}
+	default:
+		return fmt.Errorf("unknown server side encryption %q", o.sse)
+	}
+	known := false
+	for _, charset := range metaCharsets {
+		known = known || o.metaCharset == charset
+	}
+	if !known {
+		return fmt.Errorf("unknown metadata charset %q, expected one of %s", o.metaCharset, strings.Join(metaCharsets, ", "))
+	}
+	if (o.lockMode == "") != (o.lockRetain == 0) {
+		return errors.New("-object-lock-mode and -object-lock-retain must be given together")
+	}
+	if o.lockMode != "" {
+		known = false
+		for _, mode := range s3.ObjectLockMode_Values() {
+			known = known || o.lockMode == mode
+		}
+		if !known {
+			return fmt.Errorf("unknown object lock mode %q, expected one of %s", o.lockMode, strings.Join(s3.ObjectLockMode_Values(), ", "))
+		}
+	}
+	if o.legalHold != "" {
+		known = false
+		for _, status := range s3.ObjectLockLegalHoldStatus_Values() {
+			known = known || o.legalHold == status
+		}
+		if !known {
+			return fmt.Errorf("unknown legal hold status %q, expected one of %s", o.legalHold, strings.Join(s3.ObjectLockLegalHoldStatus_Values(), ", "))
+		}
+	}
+	if o.acl != "" {
+		known = false
+		for _, acl := range s3.ObjectCannedACL_Values() {
+			known = known || o.acl == acl
+		}
+		if !known {
+			return fmt.Errorf("unknown canned ACL %q, expected one of %s", o.acl, strings.Join(s3.ObjectCannedACL_Values(), ", "))
+		}
+	}
+	if o.checksum != "" {
+		known = false
+		for _, algorithm := range s3.ChecksumAlgorithm_Values() {
+			known = known || o.checksum == algorithm
+		}
+		if !known {
+			return fmt.Errorf("unknown checksum algorithm %q, expected one of %s", o.checksum, strings.Join(s3.ChecksumAlgorithm_Values(), ", "))
+		}
+	}
+	return nil
+}
+
+// metadataSize returns the size of the user metadata of an object as S3
+// counts it, the bytes of every key and value.
+func (o objectOptions) metadataSize() int {
+	size := 0
+	for i := 1; i <= o.metaCount; i++ {
+		size += len(fmt.Sprintf("%s-%v", "test-metadata-key", i)) + o.metaSize
+	}
+	return size
+}
+
+// uploadBlob does an upload to the S3/Minio server, the metadata values
+// are drawn from rng.
+func uploadBlob(ctx context.Context, uploader *s3manager.Uploader, body io.ReadSeeker, objectName string, opts objectOptions, rng *rand.Rand) (*s3manager.UploadOutput, error) {
+	input := uploadInput(body, objectName, opts, rng)
+	var reqOpts []request.Option
+	if opts.ifAbsent {
+		reqOpts = append(reqOpts, ifNoneMatchAny)
+	}
+	out, err := uploader.UploadWithContext(ctx, input, s3manager.WithUploaderRequestOptions(reqOpts...))
+	if opts.acl != "" && isACLUnsupported(err) {
+		// Left as is so that the caller tells the refusal apart.
+		return out, err
+	}
+	if aerr, ok := err.(awserr.Error); ok && opts.checksum != "" {
+		switch aerr.Code() {
+		case "NotImplemented", "InvalidArgument", "InvalidRequest":
+			err = fmt.Errorf("%v, the backend may not support -checksum %s", err, opts.checksum)
+		}
+	}
+	if aerr, ok := err.(awserr.Error); ok && (opts.lockMode != "" || opts.legalHold != "") {
+		switch aerr.Code() {
+		case "InvalidRequest", "InvalidArgument", "NotImplemented":
+			err = fmt.Errorf("%v, object lock may not be enabled on bucket %s", err, bucketFor(objectName))
+		}
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusBadRequest && opts.metaCount > 0 {
+		err = fmt.Errorf("metadata rejected, %d entries of %d %s bytes: %v", opts.metaCount, opts.metaSize, opts.metaCharset, err)
+	}
+	return out, err
+}
+
+// uploadInput returns the input of the upload of objectName with the
+// settings of opts, the metadata values are drawn from rng.
+func uploadInput(body io.ReadSeeker, objectName string, opts objectOptions, rng *rand.Rand) *s3manager.UploadInput {
+	meta := map[string]*string{}
+	var metadataValue string = metaValue(rng, opts.metaCharset, opts.metaSize)
+	var key string
+	for i := 1; i <= opts.metaCount; i++ {
+		key = fmt.Sprintf("%s-%v", "test-metadata-key", i)
+		meta[key] = &metadataValue
+	}
+	input := &s3manager.UploadInput{
+		Body:     body,
+		Bucket:   aws.String(bucketFor(objectName)),
+		Key:      aws.String(objectName),
+		Metadata: meta,
+	}
+	if opts.sse != "" {
+		input.ServerSideEncryption = aws.String(opts.sse)
+	}
+	if opts.sseKMSKey != "" {
+		input.SSEKMSKeyId = aws.String(opts.sseKMSKey)
+	}
+	if opts.storageClass != "" {
+		input.StorageClass = aws.String(opts.storageClass)
+	}
+	if opts.acl != "" {
+		input.ACL = aws.String(opts.acl)
+	}
+	if opts.contentType != "" {
+		input.ContentType = aws.String(opts.contentType)
+	}
+	if opts.contentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.contentEncoding)
+	}
+	if opts.tagging != "" {
+		input.Tagging = aws.String(opts.tagging)
+	}
+	if opts.checksum != "" {
+		input.ChecksumAlgorithm = aws.String(opts.checksum)
+	}
+	if opts.lockMode != "" {
+		// Retained from the start of the upload.
+		input.ObjectLockMode = aws.String(opts.lockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(opts.lockRetain))
+	}
+	if opts.legalHold != "" {
+		input.ObjectLockLegalHoldStatus = aws.String(opts.legalHold)
+	}
+	return input
+}
+
+// manualParts controls the uploads of -op manual-multipart.
+type manualParts struct {
+	// Exact number of parts, 0 splits the objects into parts of
+	// partSize bytes.
+	count    int
+	partSize int64
+
+	// Pause before every part after the first.
+	delay time.Duration
+
+	// Leave the uploads incomplete instead of completing them.
+	skipComplete bool
+
+	// Latency of every part, and the parts and bytes uploaded.
+	lat             *latencies
+	uploaded, bytes *int64
+}
+
+// layout returns the size of the parts of an object of size bytes and
+// their number, the last part holds the rest.
+func (m manualParts) layout(size int64) (int64, int) {
+	partSize := m.partSize
+	if m.count > 0 {
+		partSize = (size + int64(m.count) - 1) / int64(m.count)
+	}
+	if partSize < 1 {
+		partSize = 1
+	}
+	parts := int((size + partSize - 1) / partSize)
+	if parts == 0 {
+		// Empty objects are uploaded as a single empty part.
+		parts = 1
+	}
+	return partSize, parts
+}
+
+// manualMultipartUpload uploads size bytes of the body of input with
+// CreateMultipartUpload, UploadPart and CompleteMultipartUpload called
+// directly, one part after the other. Every part is a section of the
+// body, which has to be an io.ReaderAt, so that nothing is copied. The
+// upload is aborted when a part fails or ctx is done.
+func manualMultipartUpload(ctx context.Context, svc s3iface.S3API, input *s3manager.UploadInput, size int64, m manualParts) (*s3manager.UploadOutput, error) {
+	src, ok := input.Body.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("the body of a manual multipart upload has to be an io.ReaderAt")
+	}
+	create := &s3.CreateMultipartUploadInput{}
+	awsutil.Copy(create, input)
+	created, err := svc.CreateMultipartUploadWithContext(ctx, create)
+	if err != nil {
+		return nil, err
+	}
+	abort := func(err error) (*s3manager.UploadOutput, error) {
+		// Aborted even when ctx is done, so that no parts are left.
+		svc.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: created.UploadId,
+		})
+		return nil, err
+	}
+	partSize, parts := m.layout(size)
+	completed := make([]*s3.CompletedPart, 0, parts)
+	for i := 1; i <= parts; i++ {
+		if i > 1 && m.delay > 0 {
+			select {
+			case <-ctx.Done():
+				return abort(ctx.Err())
+			case <-time.After(m.delay):
+			}
+		}
+		off := int64(i-1) * partSize
+		n := size - off
+		if n > partSize {
+			n = partSize
+		}
+		if n < 0 {
+			n = 0
+		}
+		partStart := time.Now()
+		out, err := svc.UploadPartWithContext(ctx, &s3.UploadPartInput{
+			Bucket:        input.Bucket,
+			Key:           input.Key,
+			UploadId:      created.UploadId,
+			PartNumber:    aws.Int64(int64(i)),
+			Body:          io.NewSectionReader(src, off, n),
+			ContentLength: aws.Int64(n),
+		})
+		if err != nil {
+			return abort(fmt.Errorf("part %d: %w", i, err))
+		}
+		m.lat.add(time.Since(partStart))
+		atomic.AddInt64(m.uploaded, 1)
+		atomic.AddInt64(m.bytes, n)
+		completed = append(completed, &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(int64(i))})
+	}
+	if m.skipComplete {
+		return &s3manager.UploadOutput{UploadID: aws.StringValue(created.UploadId)}, nil
+	}
+	done, err := svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        created.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return abort(err)
+	}
+	return &s3manager.UploadOutput{
+		Location:  aws.StringValue(done.Location),
+		VersionID: done.VersionId,
+		UploadID:  aws.StringValue(created.UploadId),
+		ETag:      done.ETag,
+	}, nil
+}
+
+// ifNoneMatchAny makes the request creating an object fail with 412
+// Precondition Failed when the key already exists. Multipart uploads are
+// only conditional on their completion.
+func ifNoneMatchAny(r *request.Request) {
+	switch r.Operation.Name {
+	case "PutObject", "CompleteMultipartUpload":
+		r.HTTPRequest.Header.Set("If-None-Match", "*")
+	}
+}
+
+// refusedUpload is the outcome of an upload the backend refused on
+// purpose, such as an existing key with -if-absent. It is neither a
+// success nor a failure, refused uploads are counted apart from both and
+// left out of the throughput and the latencies.
+type refusedUpload struct {
+	reason string
+}
+
+func (e refusedUpload) Error() string {
+	return "upload refused, " + e.reason
+}
+
+// isRefused reports whether err is a refusedUpload.
+func isRefused(err error) bool {
+	var refused refusedUpload
+	return errors.As(err, &refused)
+}
+
+// isACLUnsupported reports whether err, or the error it wraps, is the
+// refusal of a backend that does not support ACLs on objects.
+func isACLUnsupported(err error) bool {
+	for err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		switch aerr.Code() {
+		case "NotImplemented", "AccessControlListNotSupported":
+			return true
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
+
+// isPreconditionFailed reports whether err, or the error it wraps, is a
+// 412 Precondition Failed response.
+func isPreconditionFailed(err error) bool {
+	for err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusPreconditionFailed {
+			return true
+		}
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
+
+// presignedPut uploads body with a plain HTTP PUT to a presigned URL of
+// objectName, the request itself is not signed by the SDK. Returns the
+// ETag of the uploaded object.
+func presignedPut(ctx context.Context, svc s3iface.S3API, httpClient *http.Client, userAgent string, objectName string, body io.ReadSeeker, size int64, expiry time.Duration) (string, error) {
+	req, _ := svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(bucketFor(objectName)),
+		Key:    aws.String(objectName),
+	})
+	url, err := req.Presign(expiry)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
+	if err != nil {
+		return "", err
+	}
+	httpReq.ContentLength = size
+	if userAgent != "" {
+		httpReq.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("presigned PUT failed with %s", resp.Status)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// verifyUpload checks that an uploaded object matches its body. The ETag
+// of a single part upload is the MD5 of the body, the ETag of a multipart
+// upload is not so only the size of the object is compared.
+func verifyUpload(ctx context.Context, svc s3iface.S3API, out *s3manager.UploadOutput, objectName string, md5sum string, size int64) error {
+	if out.UploadID == "" {
+		if etag := strings.Trim(aws.StringValue(out.ETag), `"`); etag != md5sum {
+			return fmt.Errorf("ETag %s does not match MD5 %s", etag, md5sum)
+		}
+		return nil
+	}
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketFor(objectName)),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		return err
+	}
+	if n := aws.Int64Value(head.ContentLength); n != size {
+		return fmt.Errorf("size %d does not match uploaded size %d", n, size)
+	}
+	return nil
+}
+
+// inconsistentRead is the error of a read right after a write that did not
+// find the object or returned other bytes than uploaded.
+type inconsistentRead struct {
+	reason string
+}
+
+func (e inconsistentRead) Error() string {
+	return "read after write inconsistency, " + e.reason
+}
+
+// readBack downloads objectName right after its upload and checks that it
+// has the uploaded size and MD5, an inconsistentRead is returned when not.
+func readBack(ctx context.Context, svc s3iface.S3API, objectName string, md5sum string, size int64) error {
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketFor(objectName)),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return inconsistentRead{reason: "the object was not found"}
+		}
+		return err
+	}
+	defer out.Body.Close()
+	h := md5.New()
+	n, err := io.Copy(h, out.Body)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return inconsistentRead{reason: fmt.Sprintf("read %d bytes, uploaded %d", n, size)}
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != md5sum {
+		return inconsistentRead{reason: fmt.Sprintf("read MD5 %s, uploaded %s", sum, md5sum)}
+	}
+	return nil
+}
+
+// versionRecorder writes the key and the version id of every uploaded
+// object, separated by a tab, one object per line. It is safe for
+// concurrent use.
+type versionRecorder struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+
+	// Number of uploads without a version id, the bucket is likely not
+	// versioned when it is not zero.
+	unversioned int
+}
+
+func newVersionRecorder(w io.Writer) *versionRecorder {
+	return &versionRecorder{w: bufio.NewWriter(w)}
+}
+
+func (v *versionRecorder) record(objectName, versionID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if versionID == "" {
+		v.unversioned++
+	}
+	_, err := fmt.Fprintf(v.w, "%s\t%s\n", objectName, versionID)
+	return err
+}
+
+func (v *versionRecorder) flush() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.w.Flush()
+}
+
+// manifestEntry is the line of the manifest written for an upload, which
+// parallel-get -read-manifest reads to download the uploaded objects in a
+// later run, possibly on another host. The format is stable: one JSON
+// object per line, fields may be added but are neither renamed nor
+// removed, and readers ignore the fields they do not know.
+type manifestEntry struct {
+	Key       string  `json:"key"`
+	Bucket    string  `json:"bucket"`
+	Size      int64   `json:"size"`
+	Start     string  `json:"start"`
+	LatencyMs float64 `json:"latencyMs"`
+	Success   bool    `json:"success"`
+	Error     string  `json:"error,omitempty"`
+	ETag      string  `json:"etag,omitempty"`
+	// UncompressedSize is the size before -compress, Size is the size
+	// stored and downloaded.
+	UncompressedSize int64 `json:"uncompressedSize,omitempty"`
+}
+
+// newManifestEntry returns the entry of an upload of size bytes, of which
+// stored bytes were sent and stored.
+func newManifestEntry(objectName string, size, stored int64, start time.Time, latency time.Duration, etag string, err error) manifestEntry {
+	e := manifestEntry{
+		Key:       objectName,
+		Bucket:    bucketFor(objectName),
+		Size:      stored,
+		Start:     start.UTC().Format(time.RFC3339Nano),
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+		Success:   err == nil,
+		ETag:      strings.Trim(etag, `"`),
+	}
+	if stored != size {
+		e.UncompressedSize = size
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// Number of manifest entries buffered before the workers wait for the
+// writer.
+const manifestBuffer = 1024
+
+// manifestWriter writes manifest entries as JSON lines from a goroutine
+// of its own, so that the workers only hand them over a channel instead
+// of waiting on the file.
+type manifestWriter struct {
+	entries chan manifestEntry
+	done    chan error
+}
+
+func newManifestWriter(w io.Writer) *manifestWriter {
+	m := &manifestWriter{
+		entries: make(chan manifestEntry, manifestBuffer),
+		done:    make(chan error, 1),
+	}
+	go func() {
+		buf := bufio.NewWriter(w)
+		enc := json.NewEncoder(buf)
+		var err error
+		for e := range m.entries {
+			if err == nil {
+				err = enc.Encode(e)
+			}
+		}
+		if err == nil {
+			err = buf.Flush()
+		}
+		m.done <- err
+	}()
+	return m
+}
+
+func (m *manifestWriter) write(e manifestEntry) {
+	m.entries <- e
+}
+
+// close writes the remaining entries and returns the first error.
+func (m *manifestWriter) close() error {
+	close(m.entries)
+	return <-m.done
+}
+
+// phase holds what was measured while uploading a set of objects.
+type phase struct {
+	// Operation of the phase, PUT when empty.
+	op string
+
+	// Set for LIST phases only.
+	list *listStats
+
+	concurrency int
+	count       int
+	bytes       int64
+	errs        []error
+	start       time.Time
+	elapsed     time.Duration
+	lat         *latencies
+
+	// Set when the phase ran a fixed number of workers, one entry per
+	// worker.
+	workers []workerStats
+}
+
+// workerStats is what one worker of a phase did, it is only updated by
+// that worker so that no counters are shared between workers.
+type workerStats struct {
+	objects int
+	busy    time.Duration
+}
+
+// logWorkerStats logs the objects and the busy time of every worker of
+// the phase p reported as op, followed by their spread.
+func logWorkerStats(op string, p phase) {
+	if len(p.workers) == 0 {
+		return
+	}
+	share := func(w workerStats) float64 {
+		if p.elapsed <= 0 {
+			return 0
+		}
+		return 100 * float64(w.busy) / float64(p.elapsed)
+	}
+	least, most := p.workers[0], p.workers[0]
+	idlest, busiest := p.workers[0], p.workers[0]
+	for i, w := range p.workers {
+		infof("%s worker %d: %d objects, busy %s, %.1f%% of the elapsed time", op, i+1, w.objects, w.busy, share(w))
+		if w.objects < least.objects {
+			least = w
+		}
+		if w.objects > most.objects {
+			most = w
+		}
+		if w.busy < idlest.busy {
+			idlest = w
+		}
+		if w.busy > busiest.busy {
+			busiest = w
+		}
+	}
+	infof("%s workers ran %d to %d objects, busy %.1f%% to %.1f%% of the elapsed time", op, least.objects, most.objects, share(idlest), share(busiest))
+}
+
+// errorRate returns the share of the operations of the phase that failed.
+func (p phase) errorRate() float64 {
+	if total := p.count + len(p.errs); total > 0 {
+		return float64(len(p.errs)) / float64(total)
+	}
+	return 0
+}
+
+// result holds the outcome of a benchmark run, the JSON field order is
+// part of the output format and must stay stable.
+type result struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	Type          string  `json:"type"`
+	Node          string  `json:"node"`
+	Concurrency   int     `json:"concurrency"`
+	ObjectSize    int64   `json:"objectSize"`
+	MetaCount     int     `json:"metaCount"`
+	MetaSize      int     `json:"metaSize"`
+	ElapsedMs     float64 `json:"elapsedMs"`
+	ObjsPerSec    float64 `json:"objsPerSec"`
+	MbitPerSec    float64 `json:"mbitPerSec"`
+	StartTs       string  `json:"startTs"`
+	EndTs         string  `json:"endTs"`
+	PartSize      int64   `json:"partSize"`
+	PayloadType   string  `json:"payloadType"`
+	StorageClass  string  `json:"storageClass"`
+	Partial       bool    `json:"partial"`
+	TagCount      int     `json:"tagCount"`
+	PartConc      int     `json:"partConcurrency"`
+	Checksum      string  `json:"checksum"`
+	TotalObjects  int     `json:"totalObjects"`
+	TotalBytes    int64   `json:"totalBytes"`
+	TargetRate    float64 `json:"targetRate"`
+	RateSustained bool    `json:"rateSustained"`
+	MD5Disabled   bool    `json:"contentMD5Disabled"`
+	PrecondFailed int64   `json:"preconditionFailed"`
+	Version       string  `json:"version"`
+	ErrorRate     float64 `json:"errorRate"`
+	Compression   string  `json:"compression"`
+	CompressedB   int64   `json:"compressedBytes"`
+	SetupIncluded bool    `json:"setupIncluded"`
+	SlowCount     int64   `json:"slowCount"`
+	Signing       string  `json:"payloadSigning"`
+	Inconsistent  int64   `json:"inconsistentReads"`
+	Throttled     int64   `json:"throttleCount"`
+	ACLRejected   int64   `json:"aclRejected"`
+
+	// List is only reported for LIST results.
+	List *listStats `json:"list,omitempty"`
+
+	// Latency is only reported when requested with -latency.
+	Latency *latencyStats `json:"latency,omitempty"`
+
+	// Resources are only reported when requested with -resource-stats.
+	Resources *resourceStats `json:"resources,omitempty"`
+
+	elapsed time.Duration
+	// columns are the feature columns printed after the baseline ones.
+	columns []rowColumns
+}
+
+// resultHeader names the baseline columns of a result row, in order.
+// They are printed in every row and must stay as they are, existing
+// parsers depend on them.
+var resultHeader = []string{
+	"Type",
+	"Node Number",
+	"Concurrency",
+	"Object Size (bytes)",
+	"Metadata Entries",
+	"Metadata Size (bytes)",
+	"Elapsed Time",
+	"Speed (objs/sec)",
+	"Bandwidth (MBit/sec)",
+	"Start Timestamp",
+	"End Timestamp",
+}
+
+// rowColumns are the columns of a feature, appended to a row after the
+// baseline columns when the feature is in use.
+type rowColumns struct {
+	names []string
+	// flags enable the columns when any of them is set.
+	flags []string
+	// shown reports whether the columns are printed, over flags when set.
+	shown  func() bool
+	values func(r result) string
+}
+
+// featureColumns are the feature columns in the order they are printed.
+var featureColumns = []rowColumns{
+	{names: []string{"Part Size (bytes)"}, flags: []string{"part-size"}, values: func(r result) string {
+		return strconv.FormatInt(r.PartSize, 10)
+	}},
+	{names: []string{"Payload Type"}, flags: []string{"random-payload", "unique-payload", "stream-payload", "payload-file", "payload-dir"}, values: func(r result) string {
+		return r.PayloadType
+	}},
+	{names: []string{"Storage Class"}, flags: []string{"storage-class"}, values: func(r result) string {
+		return r.StorageClass
+	}},
+	{names: []string{"Partial"}, flags: []string{"duration", "max-runtime"}, values: func(r result) string {
+		return strconv.FormatBool(r.Partial)
+	}},
+	{names: []string{"Tags"}, flags: []string{"tags"}, values: func(r result) string {
+		return strconv.Itoa(r.TagCount)
+	}},
+	{names: []string{"Part Concurrency"}, flags: []string{"part-concurrency"}, values: func(r result) string {
+		return strconv.Itoa(r.PartConc)
+	}},
+	{names: []string{"Checksum"}, flags: []string{"checksum"}, values: func(r result) string {
+		return r.Checksum
+	}},
+	{names: []string{"Total Objects", "Total Bytes"}, flags: []string{"objects", "duration"}, values: func(r result) string {
+		return fmt.Sprintf("%d;%d", r.TotalObjects, r.TotalBytes)
+	}},
+	{names: []string{"Target Rate (objs/sec)", "Rate Sustained"}, flags: []string{"rate"}, values: func(r result) string {
+		return fmt.Sprintf("%f;%t", r.TargetRate, r.RateSustained)
+	}},
+	{names: []string{"Content MD5 Disabled"}, flags: []string{"disable-content-md5"}, values: func(r result) string {
+		return strconv.FormatBool(r.MD5Disabled)
+	}},
+	{names: []string{"Precondition Failed"}, flags: []string{"if-absent"}, values: func(r result) string {
+		return strconv.FormatInt(r.PrecondFailed, 10)
+	}},
+	{names: []string{"Error Rate"}, flags: []string{"ramp-error-threshold"}, values: func(r result) string {
+		return fmt.Sprintf("%f", r.ErrorRate)
+	}},
+	{names: []string{"Compression", "Compressed Bytes"}, flags: []string{"compress"}, values: func(r result) string {
+		return fmt.Sprintf("%s;%d", r.Compression, r.CompressedB)
+	}},
+	{names: []string{"Setup Included"}, flags: []string{"include-setup"}, values: func(r result) string {
+		return strconv.FormatBool(r.SetupIncluded)
+	}},
+	{names: []string{"Slow Uploads"}, flags: []string{"slow-threshold"}, values: func(r result) string {
+		return strconv.FormatInt(r.SlowCount, 10)
+	}},
+	{names: []string{"Payload Signing"}, flags: []string{"payload-signing"}, values: func(r result) string {
+		return r.Signing
+	}},
+	{names: []string{"Inconsistent Reads"}, shown: func() bool { return *op == "put-get" }, values: func(r result) string {
+		return strconv.FormatInt(r.Inconsistent, 10)
+	}},
+	{names: []string{"Throttled"}, flags: []string{"throttle-backoff"}, values: func(r result) string {
+		return strconv.FormatInt(r.Throttled, 10)
+	}},
+	{names: []string{"ACL Rejected"}, flags: []string{"acl"}, values: func(r result) string {
+		return strconv.FormatInt(r.ACLRejected, 10)
+	}},
+}
+
+// shownColumns returns the feature columns of the features in use, all
+// of them with -all-columns.
+func shownColumns() []rowColumns {
+	if *allColumns {
+		return featureColumns
+	}
+	var shown []rowColumns
+	for _, c := range featureColumns {
+		if c.shown != nil {
+			if c.shown() {
+				shown = append(shown, c)
+			}
+			continue
+		}
+		for _, name := range c.flags {
+			if isFlagSet(name) {
+				shown = append(shown, c)
+				break
+			}
+		}
+	}
+	return shown
+}
+
+// listHeader names the columns appended to a LIST row.
+var listHeader = []string{
+	"Keys Listed",
+	"Pages",
+}
+
+// latencyHeader names the columns appended to a row when the latency is
+// reported.
+var latencyHeader = []string{
+	"Latency Min (ms)",
+	"Latency P50 (ms)",
+	"Latency P90 (ms)",
+	"Latency P99 (ms)",
+	"Latency Max (ms)",
+}
+
+// resourceHeader names the columns appended to a row when the resource
+// use of the client is reported, after the latency columns.
+var resourceHeader = []string{
+	"CPU Seconds",
+	"Peak Goroutines",
+	"Allocated Bytes",
+	"Allocations",
+}
+
+// header returns the names of the columns printed by row.
+func (r result) header() []string {
+	header := append([]string{}, resultHeader...)
+	for _, c := range r.columns {
+		header = append(header, c.names...)
+	}
+	if r.List != nil {
+		header = append(header, listHeader...)
+	}
+	if r.Latency != nil {
+		header = append(header, latencyHeader...)
+	}
+	if r.Resources != nil {
+		header = append(header, resourceHeader...)
+	}
+	return header
+}
+
+// row formats the result as a semicolon separated row.
+func (r result) row() string {
+	row := fmt.Sprintf("%s;%s;%d;%d;%d;%d;%s;%f;%f;%s;%s", r.Type, r.Node, r.Concurrency, r.ObjectSize, r.MetaCount, r.MetaSize, r.elapsed, r.ObjsPerSec, r.MbitPerSec, r.StartTs, r.EndTs)
+	for _, c := range r.columns {
+		row += ";" + c.values(r)
+	}
+	if l := r.List; l != nil {
+		row += fmt.Sprintf(";%d;%d", l.Keys, l.Pages)
+	}
+	if l := r.Latency; l != nil {
+		row += fmt.Sprintf(";%f;%f;%f;%f;%f", l.MinMs, l.P50Ms, l.P90Ms, l.P99Ms, l.MaxMs)
+	}
+	if res := r.Resources; res != nil {
+		row += fmt.Sprintf(";%f;%d;%d;%d", res.CPUSeconds, res.PeakGoroutines, res.AllocBytes, res.Allocs)
+	}
+	return row
+}
+
+// printResult writes the result to w in the requested format, the csv
+// row is preceded by the column names when header is set. Everything is
+// written at once so that appended rows stay whole.
+func printResult(w io.Writer, r result, format string, header bool) error {
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	default:
+		if header {
+			fmt.Fprintln(&buf, strings.Join(r.header(), ";"))
+		}
+		fmt.Fprintln(&buf, r.row())
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// histogramHeader names the columns of the -histogram file.
+var histogramHeader = []string{"Type", "Node", "Concurrency", "Object Size", "Start Timestamp", "Lower (ms)", "Upper (ms)", "Count"}
+
+// writeHistogram writes a line per bucket of the latency histogram of the
+// row r, which identifies the row without naming any object.
+func writeHistogram(w io.Writer, r result, buckets []histogramBucket, header bool) error {
+	var buf bytes.Buffer
+	if header {
+		fmt.Fprintln(&buf, strings.Join(histogramHeader, ";"))
+	}
+	for _, b := range buckets {
+		fmt.Fprintf(&buf, "%s;%s;%d;%d;%s;%f;%f;%d\n", r.Type, r.Node, r.Concurrency, r.ObjectSize, r.StartTs, b.LowerMs, b.UpperMs, b.Count)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// summaryRow is a result row of the -summary-file with the latency
+// histogram of the row.
+type summaryRow struct {
+	result
+	Histogram []histogramBucket `json:"histogram"`
+}
+
+// errorCount counts the failed operations of one kind, with the message
+// of the first of them.
+type errorCount struct {
+	Kind    string `json:"kind"`
+	Count   int    `json:"count"`
+	Example string `json:"example"`
+}
+
+// runSummary is the -summary-file, everything known about a run in one
+// JSON document.
+type runSummary struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Version       string            `json:"version"`
+	Hostname      string            `json:"hostname"`
+	GoVersion     string            `json:"goVersion"`
+	OS            string            `json:"os"`
+	Arch          string            `json:"arch"`
+	CPUs          int               `json:"cpus"`
+	StartTs       string            `json:"startTs"`
+	EndTs         string            `json:"endTs"`
+	Config        map[string]string `json:"config"`
+	Rows          []summaryRow      `json:"rows"`
+	Errors        []errorCount      `json:"errors"`
+	SLAFailures   []string          `json:"slaFailures"`
+}
+
+// errorKind returns the S3 error code of err, the kind of transport
+// timeout or what else failed.
+func errorKind(err error) string {
+	var inconsistent inconsistentRead
+	if errors.As(err, &inconsistent) {
+		return "InconsistentRead"
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		if timeout := transportTimeout(aerr); timeout != "" {
+			return timeout + "-timeout"
+		}
+		return aerr.Code()
+	}
+	return "other"
+}
+
+// errorBreakdown counts errs by kind, the most frequent kind first.
+func errorBreakdown(errs []error) []errorCount {
+	counts := []errorCount{}
+	index := map[string]int{}
+	for _, err := range errs {
+		kind := errorKind(err)
+		i, ok := index[kind]
+		if !ok {
+			i = len(counts)
+			index[kind] = i
+			counts = append(counts, errorCount{Kind: kind, Example: err.Error()})
+		}
+		counts[i].Count++
+	}
+	sort.SliceStable(counts, func(i, j int) bool { return counts[i].Count > counts[j].Count })
+	return counts
+}
+
+// summaryConfig returns the resolved configuration of a run as printed
+// by printConfig, without the access key.
+func summaryConfig(endpoints []string, concurrency int, nodeNumber string) map[string]string {
+	config := map[string]string{
+		"endpoints":   strings.Join(endpoints, ","),
+		"buckets":     strings.Join(allBuckets(), ","),
+		"concurrency": strconv.Itoa(concurrency),
+		"node":        nodeNumber,
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		config["-"+f.Name] = f.Value.String()
+	})
+	return config
+}
+
+// writeFileAtomic writes data to a temporary file next to path and
+// renames it to path, so that an interrupted write leaves no partial
+// file behind.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// appendFile appends to a file shared with other processes, possibly on
+// other hosts over NFS. Every write holds an exclusive advisory lock so
+// that the rows of concurrent nodes do not interleave.
+type appendFile struct {
+	f *os.File
+}
+
+func openAppendFile(path string) (*appendFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &appendFile{f: f}, nil
+}
+
+func (a *appendFile) Write(p []byte) (int, error) {
+	fd := int(a.f.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return 0, fmt.Errorf("locking %s failed: %v", a.f.Name(), err)
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+	return a.f.Write(p)
+}
+
+func (a *appendFile) Close() error {
+	return a.f.Close()
+}
+
+var (
+	logLevel     = flag.String("log-level", "info", "Lowest level of the messages logged to stderr, one of "+strings.Join(levelNames, ", ")+".")
+	dryRun       = flag.Bool("dry-run", false, "Print the resolved configuration and exit without uploading.")
+	dryRunHead   = flag.Bool("dry-run-head", false, "Check with -dry-run that the first bucket is reachable on the first endpoint with a HEAD request.")
+	ensure       = flag.Bool("ensure-bucket", false, "Create the bucket before the run when it does not exist.")
+	bucketRegion = flag.String("bucket-region", "", "Region the bucket is created in with -ensure-bucket, the request region when empty.")
+	includeSetup = flag.Bool("include-setup", false, "Count the creation of the sessions in the elapsed time of the first row.")
+	showVersion  = flag.Bool("version", false, "Print the version, commit and build date and exit.")
+	configFile   = flag.String("config", "", "JSON file with the environment variables and flags of the run, see the README.")
+	compareFile  = flag.String("compare", "", "JSON file with the flags of two configurations a and b to run alternately and compare, see the README.")
+	compareRuns  = flag.Int("compare-runs", 3, "Number of runs of each configuration with -compare.")
+	compareNoise = flag.Float64("compare-noise", 5, "Smallest change in percent reported as a difference with -compare.")
+	op           = flag.String("op", "put", "Operation to benchmark, one of "+strings.Join(operations, ", ")+".")
+	urlExpiry    = flag.Duration("presign-expiry", 15*time.Minute, "Expiry of the presigned URLs of -op presigned-put.")
+	batchSize    = flag.Int("batch-size", 1, "Number of objects deleted per request with -op delete, DeleteObjects is used above 1.")
+	prefix       = flag.String("prefix", "", "Prefix of the keys listed with -op list and of the uploads aborted with -abort-incomplete.")
+	abortLeft    = flag.Bool("abort-incomplete", false, "Abort the incomplete multipart uploads under -prefix left in the buckets at the end of the run.")
+	pageSize     = flag.Int("page-size", 1000, "Maximum number of keys per page with -op list.")
+	maxKeysTotal = flag.Int("max-keys-total", 0, "Stop listing after this many keys with -op list, 0 lists all keys.")
+	keyTemplate  = flag.String("key-template", "", "Template of the object keys with the {node}, {i}, {rand}, {ts} and {prefix} placeholders, object-{node}-{i} when empty.")
+	prefixCount  = flag.Int("prefix-count", 0, "Spread the keys evenly over this many prefixes p000/, p001/ and so on, at {prefix} of -key-template or in front of the key.")
+	objectSize   = sizeFlag("size", defaultObjectSize, "Size of the object to upload, in bytes or with a unit such as 512KB, 10MB or 1GiB.")
+	metaCount    = flag.Int("meta-count", defaultMetaCount, "Metadata entry count of the object to upload.")
+	metaSize     = flag.Int("meta-size", defaultMetaSize, "Metadata size of each entry of the object to upload.")
+	metaCharset  = flag.String("meta-charset", "ascii", "Characters of the metadata values, one of "+strings.Join(metaCharsets, ", ")+".")
+	sse          = flag.String("sse", "", "Server side encryption of the uploaded objects, either AES256 or aws:kms.")
+	sseKMSKey    = flag.String("sse-kms-key", "", "KMS key id used with -sse aws:kms.")
+	storageClass = flag.String("storage-class", "", "Storage class of the uploaded objects, the backend default is used when empty.")
+	acl          = flag.String("acl", "", "Canned ACL of the uploaded objects, such as private or public-read, none is sent when empty.")
+	timeFormat   = flag.String("time-format", "iso8601", "Format of the start and end timestamps, in UTC, one of "+strings.Join(timeFormats, ", ")+".")
+	payloadSig   = flag.String("payload-signing", "signed", "How the body of uploads is signed, one of "+strings.Join(payloadSignings, ", ")+".")
+	compress     = flag.String("compress", "none", "Compress every payload on the client before uploading it, one of "+strings.Join(compressions, ", ")+".")
+	contentType  = flag.String("content-type", "", "Content type of the uploaded objects, the SDK default is used when empty.")
+	checksum     = flag.String("checksum", "", "Checksum algorithm computed by the client for every upload, one of "+strings.Join(s3.ChecksumAlgorithm_Values(), ", ")+".")
+	tags         = flag.String("tags", "", "Tags of the uploaded objects as k1=v1,k2=v2.")
+	concFlag     = flag.Int("concurrency", 0, "Number of objects uploaded at the same time, overrides the CONCURRENCY environment variable.")
+	objectCount  = flag.Int("objects", 0, "Number of objects to upload, CONCURRENCY or -workers of them at the same time, CONCURRENCY when 0.")
+	nodeFlag     = flag.String("node", "", "Node number used in the object names, overrides the NODE environment variable.")
+	bucketList   = flag.String("buckets", "", "Comma separated buckets to spread the objects over by a hash of their key, overrides the BUCKET environment variable.")
+	endpointList = flag.String("endpoints", "", "Comma separated endpoints to upload to round-robin, overrides the ENDPOINTS and ENDPOINT environment variables.")
+	credsFile    = flag.String("creds-file", "", "File holding the access key and the secret key on two lines, or two comma separated files holding one each, instead of ACCESSKEY and SECRETKEY.")
+	creds        = flag.String("creds", "static", "Credentials, static uses ACCESSKEY and SECRETKEY, chain falls back to the default AWS credential chain when they are not set.")
+	userAgent    = flag.String("user-agent", "perftest/"+version, "User-Agent header of every request, the SDK default when empty.")
+	region       = flag.String("region", defaultRegion, "Region used to sign the requests, AWS_REGION is used when not set.")
+	partSize     = flag.Int64("part-size", defaultPartSize, "Part size in bytes used for multipart uploads, at least 5MiB.")
+	partConc     = flag.Int("part-concurrency", s3manager.DefaultUploadConcurrency, "Number of parts of a multipart upload uploaded in parallel for each object.")
+	partCount    = flag.Int("part-count", 0, "Split every object into exactly this many parts with -op manual-multipart instead of parts of -part-size.")
+	partDelay    = flag.Duration("part-delay", 0, "Pause this long before every part after the first with -op manual-multipart.")
+	skipComplete = flag.Bool("skip-complete", false, "Leave the uploads of -op manual-multipart incomplete instead of completing them.")
+	pathStyle    = flag.Bool("path-style", true, "Address buckets in the path, use -path-style=false for virtual hosted style addressing.")
+	disableSSL   = flag.Bool("disable-ssl", false, "Use plain HTTP for endpoints given without a scheme.")
+	disableMD5   = flag.Bool("disable-content-md5", false, "Do not compute the Content-MD5 of uploaded parts, to measure the transfer without the hashing cost.")
+	insecure     = flag.Bool("insecure-skip-verify", false, "Do not verify the TLS certificate of the endpoint.")
+	maxIdleConns = flag.Int("max-idle-conns", 0, "Maximum number of idle connections over all endpoints, -max-idle-conns-per-host times the number of endpoints by default.")
+	perHostIdle  = flag.Int("max-idle-conns-per-host", 0, "Maximum number of idle connections per endpoint, the number of requests in flight by default.")
+	idleTimeout  = flag.Duration("idle-conn-timeout", 90*time.Second, "Time an idle connection is kept open.")
+	dialTimeout  = flag.Duration("dial-timeout", 0, "Time allowed to connect to an endpoint, 30s when 0.")
+	headerTmout  = flag.Duration("response-header-timeout", 0, "Time allowed for the response headers once a request was sent, unlimited when 0.")
+	retries      = flag.Int("retries", client.DefaultRetryerMaxNumRetries, "Maximum number of retries of a failed request.")
+	retryBackoff = flag.Duration("retry-backoff", client.DefaultRetryerMinRetryDelay, "Delay before the first retry of a failed request, doubled on every further retry.")
+	throttleWait = flag.Duration("throttle-backoff", client.DefaultRetryerMinThrottleDelay, "Delay before the first retry of a request throttled with 503 SlowDown, doubled on every further retry.")
+	lockMode     = flag.String("object-lock-mode", "", "Object lock retention mode of the uploads, one of "+strings.Join(s3.ObjectLockMode_Values(), ", ")+", requires -object-lock-retain.")
+	lockRetain   = flag.String("object-lock-retain", "", "Retain the uploaded objects for this long after their upload, for example +1h.")
+	legalHold    = flag.String("legal-hold", "", "Object lock legal hold status of the uploads, one of "+strings.Join(s3.ObjectLockLegalHoldStatus_Values(), ", ")+".")
+	ifAbsent     = flag.Bool("if-absent", false, "Upload with If-None-Match: * so that existing keys are not overwritten, refused uploads are counted as precondition failed.")
+	manifestFile = flag.String("manifest", "", "Write a JSON line per upload, warmup included, with its key, bucket, size, start, latency, outcome and ETag to this file.")
+	writeKeys    = flag.String("write-manifest", "", "Write the lines of -manifest for the measured uploads only, warmup left out, to this file for parallel-get -read-manifest.")
+	recordVers   = flag.String("record-versions", "", "Write the key and the version id of every uploaded object to this file.")
+	maxRuntime   = flag.Duration("max-runtime", 0, "Stop the run gracefully once this much time passed since the start, including warmup, iterations and cleanup, 0 disables the limit.")
+	timeout      = flag.Duration("timeout", 0, "Fail uploads taking longer than this, 0 disables the timeout.")
+	workers      = flag.Int("workers", 0, "Maximum number of uploads running at the same time, 0 starts all of them at once.")
+	rampStart    = flag.Int("ramp-start", 1, "Number of workers of the first ramp step.")
+	rampStep     = flag.Int("ramp-step", 0, "Add this many workers every -ramp-interval until CONCURRENCY workers upload, 0 disables the ramp.")
+	rampInterval = flag.Duration("ramp-interval", 10*time.Second, "Duration of a ramp step.")
+	rampMode     = flag.String("ramp-mode", "linear", "Growth of the ramp, linear by -ramp-step or exponential by -ramp-factor.")
+	rampFactor   = flag.Float64("ramp-factor", 2, "Multiply the workers by this factor every -ramp-interval with -ramp-mode exponential.")
+	rampErrors   = flag.Float64("ramp-error-threshold", 0, "Stop the ramp after a step in which more than this share of the uploads failed, 0 disables the check.")
+	autotune     = flag.Bool("autotune", false, "Search the number of workers, up to CONCURRENCY, reaching the peak throughput with short probes.")
+	tuneProbe    = flag.Duration("autotune-probe", 10*time.Second, "Duration of every -autotune probe.")
+	tuneGain     = flag.Float64("autotune-gain", 0.05, "Smallest share by which the throughput has to grow for -autotune to try more workers.")
+	tuneP99      = flag.Duration("autotune-max-p99", 0, "Stop adding workers with -autotune once the p99 latency of a probe exceeds this, 0 disables the check.")
+	mix          = flag.String("mix", "", "Mix uploads and downloads with a put:get ratio such as 70:30.")
+	mixSeed      = flag.Int("mix-seed", 10, "Number of objects uploaded before a -mix run for the downloads.")
+	duration     = flag.Duration("duration", 0, "Keep uploading for this long with CONCURRENCY workers instead of uploading CONCURRENCY objects once.")
+	rate         = flag.Float64("rate", 0, "Target rate in objects per second, unlimited when 0.")
+	iterations   = flag.Int("iterations", 1, "Repeat the measured uploads this many times, printing a result per iteration.")
+	freshConns   = flag.Bool("fresh-connections", false, "Open new connections for every iteration instead of reusing those of the previous iteration.")
+	warmup       = flag.String("warmup", "", "Upload this many objects, or keep uploading for this duration, before the measured uploads start.")
+	randomData   = flag.Bool("random-payload", false, "Upload incompressible random data instead of a repeated character.")
+	uniqueData   = flag.Bool("unique-payload", false, "Stamp the object name into the data every 4KiB so that no two objects, or blocks, are identical.")
+	streamData   = flag.Bool("stream-payload", false, "Generate the data of every object while uploading it instead of holding it in memory.")
+	payload      = flag.String("payload-file", "", "Upload the contents of this file, repeated or truncated to -size when given.")
+	payloadDir   = flag.String("payload-dir", "", "Upload the files of this directory as they are, round-robin, with the content type guessed from their extension.")
+	payloadWts   = flag.String("payload-weights", "", "Pick the files of -payload-dir by weight instead of round-robin, as NAME=WEIGHT,... where unlisted files weigh 1.")
+	sizeDist     = flag.String("size-distribution", "", "Draw the size of every object from uniform:MIN-MAX or lognormal:mean=MEAN,sigma=SIGMA[,max=MAX] instead of using -size.")
+	sizeJitter   = flag.String("size-jitter", "", "Vary the size of every object uniformly within this percentage of -size, such as 10%, drawn from -seed.")
+	shuffle      = flag.Bool("shuffle", false, "Dispatch the objects to the workers in a random order drawn from -seed instead of by their number.")
+	seed         = flag.Int64("seed", 0, "Seed for the random object sizes and metadata values, a time based seed is used when 0.")
+	output       = flag.String("output", "csv", "Output format of the result, either csv (semicolon separated) or json.")
+	outputFile   = flag.String("output-file", "", "Append the result to this file, locked while writing, instead of printing it to stdout.")
+	verify       = flag.Bool("verify", false, "Check the ETag, or the size for multipart uploads, of every uploaded object.")
+	latency      = flag.Bool("latency", false, "Report the min, p50, p90, p99 and max upload latencies.")
+	histogram    = flag.String("histogram", "", "Write the latency distribution of every row to this file, a line per logarithmic bucket.")
+	summaryFile  = flag.String("summary-file", "", "Write the configuration, every row with its latency histogram, the errors and the environment of the run to this JSON file once the run completes.")
+	perWorker    = flag.Bool("per-worker-stats", false, "Log the number of objects and the busy time of every worker after every row, requires -workers unless -duration, -mix, the ramp or -autotune run workers.")
+	resStats     = flag.Bool("resource-stats", false, "Report the CPU seconds, peak goroutines and allocations of the client for every row.")
+	slowThresh   = flag.Duration("slow-threshold", 0, "Log and count the uploads taking longer than this, for example 500ms.")
+	slaP99       = flag.Duration("sla-p99", 0, "Exit with code 2 when the p99 latency of a row is above this, for example 200ms.")
+	slaMinTput   = flag.Float64("sla-min-throughput", 0, "Exit with code 2 when the speed of a row in objects per second is below this.")
+	cleanup      = flag.Bool("cleanup", false, "Delete the uploaded objects once the result is printed.")
+	header       = flag.Bool("header", false, "Print the column names before the csv result row.")
+	allColumns   = flag.Bool("all-columns", false, "Print every feature column of the csv result row, also those of the features not in use.")
+	quiet        = flag.Bool("quiet", false, "Do not print the result to stdout, the exit code and the log on stderr still tell the outcome.")
+	showProgress = flag.Duration("progress", 0, "Log the number of uploaded objects, the current speed, the uploaded bytes and the errors at this interval.")
+	metricsAddr  = flag.String("metrics-addr", "", "Serve Prometheus metrics of the uploads on /metrics at this address, for example :9100.")
+	failFast     = flag.Bool("fail-fast", false, "Abort on the first upload error instead of finishing the remaining uploads.")
+)
+
+// config is the content of a -config file.
+type config struct {
+	// Environment variables, applied when they are not set.
+	Endpoint      string `json:"endpoint"`
+	Endpoints     string `json:"endpoints"`
+	AccessKey     string `json:"accessKey"`
+	SecretKey     string `json:"secretKey"`
+	SecretKeyFile string `json:"secretKeyFile"`
+	Bucket        string `json:"bucket"`
+	Concurrency   int    `json:"concurrency"`
+	Node          string `json:"node"`
+
+	// Flags by name, applied when they are not given on the command
+	// line.
+	Flags map[string]interface{} `json:"flags"`
+}
+
+// readCredsFile returns the access key and the secret key given with
+// -creds-file, either a file holding them on its first two lines or two
+// comma separated files holding one each. The errors never hold the keys.
+func readCredsFile(spec string) (string, string, error) {
+	var keys []string
+	for _, path := range strings.Split(spec, ",") {
+		b, err := ioutil.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return "", "", err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				keys = append(keys, line)
+			}
+		}
+	}
+	if len(keys) != 2 {
+		return "", "", fmt.Errorf("-creds-file %s must hold the access key and the secret key, found %d values", spec, len(keys))
+	}
+	return keys[0], keys[1], nil
+}
+
+// flagEnv names the environment variables of the flags that override
+// them, such a flag is not taken from the config file when one of its
+// variables is set.
+var flagEnv = map[string][]string{
+	"concurrency": {"CONCURRENCY"},
+	"node":        {"NODE"},
+	"buckets":     {"BUCKET"},
+	"endpoints":   {"ENDPOINTS", "ENDPOINT"},
+	"region":      {"AWS_REGION"},
+}
+
+// applyConfig reads the JSON config file at path. Flags given on the
+// command line take precedence over environment variables, which take
+// precedence over the values of the file.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg config
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err = dec.Decode(&cfg); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if cfg.SecretKeyFile != "" {
+		secret, err := ioutil.ReadFile(cfg.SecretKeyFile)
+		if err != nil {
+			return err
+		}
+		cfg.SecretKey = strings.TrimSpace(string(secret))
+	}
+	// The environment is looked at before the file adds to it.
+	inEnv := map[string]bool{}
+	for name, vars := range flagEnv {
+		for _, v := range vars {
+			if os.Getenv(v) != "" {
+				inEnv[name] = true
+			}
+		}
+	}
+	env := map[string]string{
+		"ENDPOINT":  cfg.Endpoint,
+		"ENDPOINTS": cfg.Endpoints,
+		"ACCESSKEY": cfg.AccessKey,
+		"SECRETKEY": cfg.SecretKey,
+		"BUCKET":    cfg.Bucket,
+		"NODE":      cfg.Node,
+	}
+	if cfg.Concurrency > 0 {
+		env["CONCURRENCY"] = strconv.Itoa(cfg.Concurrency)
+	}
+	for name, value := range env {
+		if _, ok := os.LookupEnv(name); !ok && value != "" {
+			os.Setenv(name, value)
+		}
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, value := range cfg.Flags {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q in config file %s", name, path)
+		}
+		if given[name] || inEnv[name] {
+			continue
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid value %q for flag %q in config file %s: %v", value, name, path, err)
+		}
+	}
+	return nil
+}
+
+// compareConfig is the content of a -compare file, the flags of the two
+// configurations by name.
+type compareConfig struct {
+	A map[string]interface{} `json:"a"`
+	B map[string]interface{} `json:"b"`
+}
+
+// loadCompareConfig reads the -compare file at path and checks that the
+// flags it sets exist in fs.
+func loadCompareConfig(fs *flag.FlagSet, path string) (compareConfig, error) {
+	var cfg compareConfig
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err = dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("invalid compare file %s: %v", path, err)
+	}
+	for _, side := range []map[string]interface{}{cfg.A, cfg.B} {
+		for name := range side {
+			if fs.Lookup(name) == nil || strings.HasPrefix(name, "compare") {
+				return cfg, fmt.Errorf("unknown flag %q in compare file %s", name, path)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// compareArgs returns the arguments of a run of one configuration, the
+// flags given on the command line followed by those of side, which take
+// precedence. The result is always printed as JSON with the latencies,
+// -quiet only applies to the comparison.
+func compareArgs(fs *flag.FlagSet, side map[string]interface{}) []string {
+	var args []string
+	fs.Visit(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "compare") && f.Name != "quiet" {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	names := make([]string, 0, len(side))
+	for name := range side {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("-%s=%v", name, side[name]))
+	}
+	return append(args, "-output=json", "-latency", "-header=false", "-output-file=")
+}
+
+// runCompareSide runs the benchmark once as a child process with args and
+// returns its result rows, its log goes to stderr.
+func runCompareSide(exe string, args []string) ([]result, error) {
+	cmd := exec.Command(exe, args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var rows []result
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var r result
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("invalid result: %v", err)
+		}
+		rows = append(rows, r)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no result printed")
+	}
+	return rows, nil
+}
+
+// comparison is the change of a metric from configuration a to b, over
+// the means of their runs.
+type comparison struct {
+	metric string
+	a, b   float64
+
+	// Change from a to b in percent.
+	change float64
+
+	// Whether the change exceeds the noise threshold as well as the
+	// spread between the runs of each configuration.
+	beyondNoise bool
+}
+
+// compareMetric compares the values of a metric over the runs of a and b,
+// noise is the smallest change in percent considered a difference.
+func compareMetric(metric string, a, b []float64, noise float64) comparison {
+	meanA, sdA := meanStddev(a)
+	meanB, sdB := meanStddev(b)
+	c := comparison{metric: metric, a: meanA, b: meanB}
+	if meanA != 0 {
+		c.change = 100 * (meanB - meanA) / meanA
+		threshold := math.Max(noise, 100*(sdA+sdB)/math.Abs(meanA))
+		c.beyondNoise = math.Abs(c.change) > threshold
+	}
+	return c
+}
+
+// compare runs the configurations of the -compare file at path runs times
+// each, alternating between them so that drift of the backend affects
+// both alike, and prints the change of the speed, bandwidth and p99
+// latency from a to b to w.
+func compare(w io.Writer, fs *flag.FlagSet, path string, runs int, noise float64) error {
+	cfg, err := loadCompareConfig(fs, path)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// Per configuration the speed, bandwidth and p99 of every run, the
+	// mean of its rows.
+	values := map[string][3][]float64{}
+	for run := 1; run <= runs; run++ {
+		for _, side := range []struct {
+			name  string
+			flags map[string]interface{}
+		}{{"a", cfg.A}, {"b", cfg.B}} {
+			infof("Running configuration %s, run %d of %d", side.name, run, runs)
+			rows, err := runCompareSide(exe, compareArgs(fs, side.flags))
+			if err != nil {
+				return fmt.Errorf("run %d of configuration %s failed: %v", run, side.name, err)
+			}
+			var speed, bandwidth, p99 float64
+			for _, r := range rows {
+				speed += r.ObjsPerSec / float64(len(rows))
+				bandwidth += r.MbitPerSec / float64(len(rows))
+				if r.Latency != nil {
+					p99 += r.Latency.P99Ms / float64(len(rows))
+				}
+			}
+			v := values[side.name]
+			v[0], v[1], v[2] = append(v[0], speed), append(v[1], bandwidth), append(v[2], p99)
+			values[side.name] = v
+		}
+	}
+	a, b := values["a"], values["b"]
+	fmt.Fprintln(w, "Metric;A;B;Change (%);Beyond Noise")
+	for i, metric := range []string{"Speed (objs/sec)", "Bandwidth (MBit/sec)", "Latency P99 (ms)"} {
+		c := compareMetric(metric, a[i], b[i], noise)
+		fmt.Fprintf(w, "%s;%f;%f;%f;%t\n", c.metric, c.a, c.b, c.change, c.beyondNoise)
+	}
+	return nil
+}
+
+// maskKey returns key with all but its last 4 characters masked, keys
+// that short are masked whole.
+func maskKey(key string) string {
+	const shown = 4
+	if len(key) <= shown {
+		return strings.Repeat("*", len(key))
+	}
+	return strings.Repeat("*", len(key)-shown) + key[len(key)-shown:]
+}
+
+// printConfig prints the resolved configuration of a run, one setting
+// per line. The access key is masked and the secret key is not printed.
+func printConfig(w io.Writer, endpoints []string, concurrency int, nodeNumber string) {
+	fmt.Fprintf(w, "endpoints=%s\n", strings.Join(endpoints, ","))
+	fmt.Fprintf(w, "buckets=%s\n", strings.Join(allBuckets(), ","))
+	fmt.Fprintf(w, "accesskey=%s\n", maskKey(os.Getenv("ACCESSKEY")))
+	fmt.Fprintf(w, "concurrency=%d\n", concurrency)
+	fmt.Fprintf(w, "node=%s\n", nodeNumber)
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "-%s=%s\n", f.Name, f.Value)
+	})
+}
+
+// headBucket checks that the bucket exists and that the credentials
+// give access to it.
+func headBucket(svc s3iface.S3API, bucket string) error {
+	_, err := svc.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	return err
+}
+
+// ensureBucket creates the bucket in region unless it already exists
+// and is owned by the caller. Buckets in us-east-1 are created without a
+// location constraint.
+func ensureBucket(svc s3iface.S3API, bucket, region string) (bool, error) {
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}
+	if region != "" && region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(region),
+		}
+	}
+	_, err := svc.CreateBucket(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeBucketAlreadyOwnedByYou {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// resolveRegion returns the region given with -region, falling back to
+// the AWS_REGION environment variable when the flag was not set.
+func resolveRegion() string {
+	if !isFlagSet("region") {
+		if envRegion := os.Getenv("AWS_REGION"); envRegion != "" {
+			return envRegion
+		}
+	}
+	return *region
+}
+
+func main() {
+	started := time.Now()
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("parallel-put %s\n", buildInfo())
+		return
+	}
+	if *configFile != "" {
+		if err := applyConfig(flag.CommandLine, *configFile); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *credsFile != "" {
+		// Like the other flags the file takes precedence over the
+		// environment variables.
+		accessKey, secretKey, err := readCredsFile(*credsFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		os.Setenv("ACCESSKEY", accessKey)
+		os.Setenv("SECRETKEY", secretKey)
+	}
+	level, err := parseLevel(*logLevel)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	minLevel = level
+
+	var resultOut io.Writer = os.Stdout
+	if *quiet {
+		// Only the exit code tells the outcome.
+		resultOut = ioutil.Discard
+	}
+	if *compareFile != "" {
+		if *compareRuns < 1 {
+			fatalf("-compare-runs must be at least 1")
+		}
+		if err := compare(resultOut, flag.CommandLine, *compareFile, *compareRuns, *compareNoise); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	if *output != "csv" && *output != "json" {
+		fatalf("Unknown output format %q", *output)
+	}
+	if *creds != "static" && *creds != "chain" {
+		fatalf("Unknown credentials mode %q", *creds)
+	}
+	if !isOperation(*op) {
+		fatalf("Unknown operation %q", *op)
+	}
+
+	resolvedRegion := resolveRegion()
+	infof("Using region %v", resolvedRegion)
+	infof("Using path style addressing %v", *pathStyle)
+	if *insecure {
+		warnf("TLS certificate verification is disabled, the endpoint is not authenticated")
+	}
+
+	knownMode := false
+	for _, mode := range rampModes {
+		knownMode = knownMode || *rampMode == mode
+	}
+	if !knownMode {
+		fatalf("Unknown -ramp-mode %q, expected one of %s", *rampMode, strings.Join(rampModes, ", "))
+	}
+	ramping := *rampStep > 0 || *rampMode == "exponential"
+	if ramping && (*rampStart < 1 || *rampInterval <= 0) {
+		fatalf("The ramp requires a positive -ramp-start and -ramp-interval")
+	}
+	if *rampMode == "exponential" && *rampFactor <= 1 {
+		fatalf("-ramp-factor must be greater than 1")
+	}
+	if *rampErrors < 0 || *rampErrors > 1 {
+		fatalf("-ramp-error-threshold must be between 0 and 1")
+	}
+	if ramping && *duration > 0 {
+		fatalf("The ramp and -duration are mutually exclusive")
+	}
+	if *perWorker && (*op == "delete" || *op == "list") {
+		fatalf("-per-worker-stats does not apply to -op delete and list")
+	}
+	if *perWorker && *workers == 0 && *duration == 0 && !ramping && *mix == "" && !*autotune {
+		// Every operation then runs on its own, no worker runs more
+		// than one and nothing would be logged.
+		fatalf("-per-worker-stats requires a pool of workers, pass -workers")
+	}
+	if *autotune {
+		if ramping || *duration > 0 || *mix != "" || *iterations > 1 || isFlagSet("objects") || *shuffle {
+			fatalf("-autotune is mutually exclusive with the ramp, -duration, -mix, -iterations, -objects and -shuffle")
+		}
+		if *op != "put" && *op != "presigned-put" {
+			fatalf("-autotune only applies to -op put and presigned-put")
+		}
+		if *tuneProbe <= 0 || *tuneGain < 0 || *tuneP99 < 0 {
+			fatalf("-autotune requires a positive -autotune-probe, and -autotune-gain and -autotune-max-p99 must not be negative")
+		}
+	}
+	var mixPut, mixGet int
+	if *mix != "" {
+		var err error
+		if mixPut, mixGet, err = parseMix(*mix); err != nil {
+			fatalf("%v", err)
+		}
+		if ramping {
+			fatalf("-mix and the ramp are mutually exclusive")
+		}
+		if *mixSeed < 0 || (*mixSeed < 1 && mixGet > 0) {
+			fatalf("-mix with downloads requires at least one seeded object")
+		}
+	}
+
+	if *partSize < s3manager.MinUploadPartSize {
+		fatalf("Part size %d is smaller than the minimum of %d bytes", *partSize, s3manager.MinUploadPartSize)
+	}
+	if *keyTemplate != "" {
+		if err := validateKeyTemplate(*keyTemplate); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *prefixCount < 0 {
+		fatalf("-prefix-count must not be negative")
+	}
+	if strings.Contains(*keyTemplate, "{prefix}") && *prefixCount == 0 {
+		fatalf("The {prefix} placeholder of -key-template requires -prefix-count")
+	}
+	warmupCount, warmupDuration, err := parseWarmup(*warmup)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *iterations < 1 {
+		fatalf("-iterations must be at least 1")
+	}
+	if *iterations > 1 && (*op != "put" && *op != "presigned-put" || *mix != "" || ramping) {
+		fatalf("-iterations only applies to -op put and presigned-put without -mix and -ramp-step")
+	}
+	if *freshConns && *iterations == 1 {
+		fatalf("-fresh-connections requires -iterations")
+	}
+	knownCompression := false
+	for _, c := range compressions {
+		knownCompression = knownCompression || *compress == c
+	}
+	if !knownCompression {
+		fatalf("Unknown -compress %q, expected one of %s", *compress, strings.Join(compressions, ", "))
+	}
+	if *compress != "none" && ((*op != "put" && *op != "manual-multipart") || *streamData) {
+		fatalf("-compress only applies to -op put and manual-multipart without -stream-payload")
+	}
+	knownFormat := false
+	for _, format := range timeFormats {
+		knownFormat = knownFormat || *timeFormat == format
+	}
+	if !knownFormat {
+		fatalf("Unknown -time-format %q, expected one of %s", *timeFormat, strings.Join(timeFormats, ", "))
+	}
+	knownSigning := false
+	for _, signing := range payloadSignings {
+		knownSigning = knownSigning || *payloadSig == signing
+	}
+	if !knownSigning {
+		fatalf("Unknown -payload-signing %q, expected one of %s", *payloadSig, strings.Join(payloadSignings, ", "))
+	}
+	if *payloadSig != "signed" && *op == "presigned-put" {
+		fatalf("-payload-signing does not apply to -op presigned-put, the URL is signed without the body")
+	}
+	if *ifAbsent && *op != "put" {
+		fatalf("-if-absent only applies to -op put")
+	}
+	if (*lockMode != "" || *legalHold != "") && *op != "put" {
+		fatalf("-object-lock-mode and -legal-hold only apply to -op put")
+	}
+	if (*lockMode != "" || *legalHold != "") && *disableMD5 && *checksum == "" {
+		fatalf("Object lock requires the Content-MD5 header or -checksum, drop -disable-content-md5 or pass -checksum")
+	}
+	if (*manifestFile != "" || *writeKeys != "") && *op != "put" && *op != "presigned-put" && *op != "put-get" {
+		fatalf("-manifest and -write-manifest only apply to -op put, presigned-put and put-get")
+	}
+	if *recordVers != "" && *op != "put" {
+		fatalf("-record-versions only applies to -op put")
+	}
+	manual := *op == "manual-multipart"
+	if (*partCount != 0 || *partDelay != 0 || *skipComplete) && !manual {
+		fatalf("-part-count, -part-delay and -skip-complete only apply to -op manual-multipart")
+	}
+	if manual {
+		if *partCount < 0 || *partDelay < 0 {
+			fatalf("-part-count and -part-delay must not be negative")
+		}
+		if *checksum != "" {
+			fatalf("-checksum does not apply to -op manual-multipart")
+		}
+		if *skipComplete && *verify {
+			fatalf("-verify cannot check the incomplete uploads of -skip-complete")
+		}
+	}
+	if *warmup != "" && *op != "put" && *op != "presigned-put" {
+		fatalf("-warmup only applies to -op put and presigned-put")
+	}
+	if *shuffle && (*duration > 0 || *mix != "" || ramping || *op == "list") {
+		fatalf("-shuffle does not apply to -duration, -mix, the ramp and -op list")
+	}
+	if *op == "put-get" && (*mix != "" || ramping) {
+		fatalf("-op put-get cannot be combined with -mix or the ramp")
+	}
+	if *partConc < 1 {
+		fatalf("-part-concurrency must be at least 1")
+	}
+	if *slaP99 < 0 || *slaMinTput < 0 {
+		fatalf("-sla-p99 and -sla-min-throughput must not be negative")
+	}
+
+	// The flags take precedence over the environment variables.
+	conc := *concFlag
+	if !isFlagSet("concurrency") {
+		concurrency := os.Getenv("CONCURRENCY")
+		if concurrency == "" {
+			fatalf("No concurrency given, set CONCURRENCY or -concurrency")
+		}
+		c, err := strconv.Atoi(concurrency)
+		if err != nil {
+			fatalf("Invalid CONCURRENCY %q: %v", concurrency, err)
+		}
+		conc = c
+	}
+	if conc < 1 {
+		fatalf("Concurrency must be at least 1, got %d", conc)
+	}
+	// CONCURRENCY objects are uploaded at once unless -objects decouples
+	// their number, CONCURRENCY or -workers of them are then in flight.
+	objects, rowConc := conc, conc
+	if *objectCount < 0 {
+		fatalf("-objects must not be negative")
+	}
+	if *objectCount > 0 {
+		if *duration > 0 || ramping {
+			fatalf("-objects does not apply to -duration and the ramp")
+		}
+		if *workers == 0 {
+			*workers = conc
+		}
+		objects, rowConc = *objectCount, *workers
+		if rowConc > objects {
+			rowConc = objects
+		}
+	}
+	nodeNumber := *nodeFlag
+	if !isFlagSet("node") {
+		nodeNumber = os.Getenv("NODE")
+	}
+
+	if *payload != "" && *randomData {
+		fatalf("-payload-file and -random-payload are mutually exclusive")
+	}
+	if *payload != "" && *streamData {
+		fatalf("-payload-file and -stream-payload are mutually exclusive")
+	}
+	if *payload != "" && *sizeDist != "" {
+		fatalf("-payload-file and -size-distribution are mutually exclusive")
+	}
+	if *payloadDir != "" && (*payload != "" || *randomData || *streamData || *sizeDist != "") {
+		fatalf("-payload-dir is mutually exclusive with -payload-file, -random-payload, -stream-payload and -size-distribution")
+	}
+	if *sizeJitter != "" && (*sizeDist != "" || *payload != "" || *payloadDir != "") {
+		fatalf("-size-jitter is mutually exclusive with -size-distribution, -payload-file and -payload-dir")
+	}
+	if *payloadWts != "" && *payloadDir == "" {
+		fatalf("-payload-weights requires -payload-dir")
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	infof("Using random seed %v", *seed)
+	var shuffled *rand.Rand
+	if *shuffle {
+		shuffled = rand.New(rand.NewSource(*seed))
+		infof("Shuffling the order the objects are dispatched in")
+	}
+
+	if isFlagSet("size") {
+		infof("Object size is %s, %d bytes", formatSize(*objectSize), *objectSize)
+	}
+	// The payload is sized to the largest object, smaller objects upload
+	// a prefix of it.
+	payloadSize := *objectSize
+	var sizes *sizeDistribution
+	if *sizeDist != "" {
+		if sizes, err = parseSizeDistribution(*sizeDist); err != nil {
+			fatalf("%v", err)
+		}
+		payloadSize = sizes.max
+	}
+	if *sizeJitter != "" {
+		if sizes, err = parseSizeJitter(*sizeJitter, *objectSize); err != nil {
+			fatalf("%v", err)
+		}
+		payloadSize = sizes.max
+		infof("Object sizes vary between %d and %d bytes", sizes.min, sizes.max)
+	}
+	var files *payloadSet
+	if *payloadDir != "" {
+		if files, err = loadPayloadDir(*payloadDir, *payloadWts); err != nil {
+			fatalf("%v", err)
+		}
+		payloadSize = int64(files.maxSize())
+		infof("Uploading %d files of %s", len(files.files), *payloadDir)
+	}
+
+	if !*streamData && files == nil && payloadSize > math.MaxInt {
+		fatalf("Objects of %d bytes do not fit in memory, pass -stream-payload", payloadSize)
+	}
+	var data []byte
+	payloadType := "synthetic"
+	switch {
+	case *streamData:
+		// Bodies are generated while uploading, see generatedPayload.
+		payloadType = "stream"
+		if *randomData {
+			payloadType = "stream-random"
+		}
+	case *randomData:
+		payloadType = "random"
+		data = make([]byte, int(payloadSize))
+		genStart := time.Now()
+		if err = fillRandom(data, runtime.NumCPU()); err != nil {
+			fatalf("%v", err)
+		}
+		infof("Generated %d random bytes in %s, random data defeats compression at the cost of startup time", len(data), time.Since(genStart))
+	case files != nil:
+		// Every upload sends one of the files, see payloadSet.
+		payloadType = "dir"
+	case *payload != "":
+		payloadType = "file"
+		size := 0
+		if isFlagSet("size") {
+			size = int(*objectSize)
+		}
+		if data, err = loadPayload(*payload, size); err != nil {
+			fatalf("%v", err)
+		}
+		*objectSize = int64(len(data))
+		payloadSize = int64(len(data))
+	default:
+		data = bytes.Repeat([]byte("a"), int(payloadSize))
+	}
+	if *uniqueData {
+		payloadType += "-unique"
+	}
+	if int64(payloadSize) <= *partSize {
+		infof("Object size %d does not exceed part size %d, objects are uploaded in a single part", payloadSize, *partSize)
+	}
+
+	tagging, tagCount, err := parseTags(*tags)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	opts := objectOptions{
+		metaCount:    *metaCount,
+		metaSize:     *metaSize,
+		metaCharset:  *metaCharset,
+		sse:          *sse,
+		sseKMSKey:    *sseKMSKey,
+		storageClass: *storageClass,
+		acl:          *acl,
+		contentType:  *contentType,
+		tagging:      tagging,
+		checksum:     strings.ToUpper(*checksum),
+		ifAbsent:     *ifAbsent,
+		lockMode:     *lockMode,
+		legalHold:    *legalHold,
+	}
+	if opts.lockRetain, err = parseRetention(*lockRetain); err != nil {
+		fatalf("%v", err)
+	}
+	if *compress == "gzip" {
+		opts.contentEncoding = "gzip"
+	}
+	if err = opts.validate(); err != nil {
+		fatalf("%v", err)
+	}
+	if size := opts.metadataSize(); size > maxUserMetadataSize {
+		warnf("Metadata of %d bytes exceeds the %d bytes S3 allows, uploads are expected to be rejected", size, maxUserMetadataSize)
+	}
+	if opts.storageClass != "" {
+		infof("Using storage class %v", opts.storageClass)
+	}
+	if opts.acl != "" {
+		if *op == "presigned-put" {
+			fatalf("-acl is not sent with -op presigned-put")
+		}
+		infof("Uploading the objects with the %s ACL", opts.acl)
+	}
+	if opts.lockMode != "" {
+		infof("Retaining the objects in %s mode for %s", opts.lockMode, opts.lockRetain)
+	}
+	if *cleanup && (opts.lockMode != "" || opts.legalHold == s3.ObjectLockLegalHoldStatusOn) {
+		warnf("Objects under retention or legal hold cannot be deleted by -cleanup")
+	}
+
+	var retried int64
+	var timeouts transportTimeouts
+	var throttled int64
+	var aborted int64
+	endpoints := resolveEndpoints()
+	if len(endpoints) == 0 {
+		fatalf("No endpoint given, set ENDPOINT, ENDPOINTS or -endpoints")
+	}
+	if buckets = resolveBuckets(); len(buckets) == 0 {
+		fatalf("No bucket given, set BUCKET or -buckets")
+	}
+	bucketCounts := map[string]*int64{}
+	for _, bucket := range buckets {
+		bucketCounts[bucket] = new(int64)
+	}
+	// Keep a connection per request in flight, net/http only keeps 2
+	// idle connections per host by default.
+	inFlight := objects
+	if *workers > 0 && *workers < objects {
+		inFlight = *workers
+	}
+	if int64(payloadSize) > *partSize {
+		inFlight *= *partConc
+	}
+	if !isFlagSet("max-idle-conns-per-host") {
+		*perHostIdle = inFlight
+	}
+	if !isFlagSet("max-idle-conns") {
+		*maxIdleConns = *perHostIdle * len(endpoints)
+	}
+	debugf("Keeping up to %d idle connections, %d per host, for %s", *maxIdleConns, *perHostIdle, *idleTimeout)
+	sessOpts := sessionOptions{
+		region:              resolvedRegion,
+		credsChain:          *creds == "chain",
+		userAgent:           *userAgent,
+		maxIdleConns:        *maxIdleConns,
+		maxIdleConnsPerHost: *perHostIdle,
+		idleConnTimeout:     *idleTimeout,
+		pathStyle:           *pathStyle,
+		disableMD5:          *disableMD5,
+		disableSSL:          *disableSSL,
+		insecureSkipVerify:  *insecure,
+		retries:             *retries,
+		retryBackoff:        *retryBackoff,
+		retried:             &retried,
+		throttleBackoff:     *throttleWait,
+		throttled:           &throttled,
+		aborted:             &aborted,
+		payloadSigning:      *payloadSig,
+		dialTimeout:         *dialTimeout,
+		headerTimeout:       *headerTmout,
+		timeouts:            &timeouts,
+	}
+	setupStart := time.Now()
+	pool := newEndpointPool(endpoints, sessOpts, *partSize, *partConc)
+	var presignClient *http.Client
+	if *op == "presigned-put" {
+		// Only the body is sent, metadata, tags and the other object
+		// settings would have to be part of the signature.
+		presignClient = newHTTPClient(sessOpts)
+		infof("Uploading through presigned URLs, metadata and tags are not sent")
+	}
+	setupTime := time.Since(setupStart)
+	debugf("Created the sessions of %d endpoints in %s", len(endpoints), setupTime)
+	if len(endpoints) > 1 {
+		infof("Uploading round-robin to %d endpoints", len(endpoints))
+	}
+	if len(buckets) > 1 {
+		infof("Spreading the objects over %d buckets", len(buckets))
+	}
+	// Operations other than uploads go to the first endpoint.
+	uploader := pool.uploaders[endpoints[0]]
+	if *dryRun {
+		printConfig(os.Stdout, endpoints, conc, nodeNumber)
+		if *dryRunHead {
+			// A single request, so that checking a large configuration
+			// does not send a request per endpoint and bucket.
+			endpoint, bucket := endpoints[0], allBuckets()[0]
+			if err := headBucket(pool.uploaders[endpoint].S3, bucket); err != nil {
+				fatalf("Bucket %s is not reachable on %s: %v", bucket, endpoint, err)
+			}
+			infof("Bucket %s is reachable on %s", bucket, endpoint)
+		}
+		return
+	}
+	if *ensure {
+		bucketRegion := *bucketRegion
+		if bucketRegion == "" {
+			bucketRegion = resolvedRegion
+		}
+		for _, bucket := range allBuckets() {
+			created, err := ensureBucket(uploader.S3, bucket, bucketRegion)
+			if err != nil {
+				fatalf("Creating bucket %s failed: %v", bucket, err)
+			}
+			if created {
+				infof("Created bucket %s in %s", bucket, bucketRegion)
+			}
+		}
+	}
+	// The first signal only stops new uploads, uploads in flight keep
+	// running until a second signal cancels uploadCtx.
+	stopCtx, stop := context.WithCancel(context.Background())
+	uploadCtx, abort := context.WithCancel(context.Background())
+	handleSignals(stop, abort)
+	// The budget stops the run like a signal and also bounds the cleanup.
+	cleanupCtx := uploadCtx
+	if *maxRuntime > 0 {
+		budget := *maxRuntime - time.Since(started)
+		time.AfterFunc(budget, func() {
+			warnf("Reached -max-runtime %s, waiting for the operations in flight", *maxRuntime)
+			stop()
+		})
+		var cancel context.CancelFunc
+		cleanupCtx, cancel = context.WithDeadline(uploadCtx, started.Add(*maxRuntime))
+		defer cancel()
+	}
+
+	md5sum := md5.Sum(data)
+	sharedMD5 := hex.EncodeToString(md5sum[:])
+	var preconditionFailed, reportedFailed int64
+	var partsUploaded, partBytes, reportedParts, reportedPartB int64
+	parts := manualParts{
+		count:        *partCount,
+		partSize:     *partSize,
+		delay:        *partDelay,
+		skipComplete: *skipComplete,
+		lat:          &latencies{},
+		uploaded:     &partsUploaded,
+		bytes:        &partBytes,
+	}
+	if manual && *compress == "gzip" {
+		infof("Laying the parts out over the compressed body of every object")
+	} else if manual {
+		each, count := parts.layout(payloadSize)
+		infof("Uploading every object in %d parts of %d bytes with the multipart API", count, each)
+		if count > 1 && each < s3manager.MinUploadPartSize {
+			warnf("Parts of %d bytes are smaller than the %d bytes S3 requires but for the last part, completing the uploads is expected to fail", each, s3manager.MinUploadPartSize)
+		}
+	}
+	var aclRejected, reportedRejected int64
+	var aclWarning sync.Once
+	var compressedBytes, reportedCompressed int64
+	var slowUploads, reportedSlow int64
+	var reportedThrottled int64
+	var inconsistentReads int64
+	// With -op put-get every upload is read back, the rows of the PUT and
+	// the GET report their own latencies.
+	readAfterWrite := *op == "put-get"
+	putLat, getLat := &latencies{}, &latencies{}
+	slowLog := &logThrottle{max: maxSlowLogsPerSec, interval: time.Second}
+	// The content types are known up front, so the counters can be
+	// updated without a lock.
+	typeCounts := map[string]*payloadTypeCounts{}
+	if files != nil {
+		for _, contentType := range files.contentTypes() {
+			typeCounts[contentType] = &payloadTypeCounts{}
+		}
+	}
+	var measuredPuts time.Duration
+	var versions *versionRecorder
+	if *recordVers != "" {
+		f, err := os.Create(*recordVers)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		versions = newVersionRecorder(f)
+	}
+	// Only the keys written are deleted by -cleanup, not those refused,
+	// failed or only read.
+	var written *keyList
+	if *cleanup {
+		written = &keyList{}
+	}
+	// doUpload returns the bytes uploaded and the ETag of the object.
+	// doUpload returns the bytes uploaded, the bytes stored, which are
+	// fewer with -compress, and the ETag of the object.
+	doUpload := func(objectName string) (int64, int64, string, error) {
+		ctx := uploadCtx
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(uploadCtx, *timeout)
+			defer cancel()
+		}
+		rng := objectRand(*seed, objectName)
+		size := int64(payloadSize)
+		if sizes != nil {
+			size = sizes.next(rng)
+		}
+		objData, objOpts := data, opts
+		var file *payloadFile
+		if files != nil {
+			file = files.pick(rng)
+			objData, size = file.data, int64(len(file.data))
+			if objOpts.contentType == "" {
+				objOpts.contentType = file.contentType
+			}
+		}
+		if sizes != nil && !*streamData {
+			// The payload is sized to the largest object.
+			objData = objData[:size]
+		}
+		var body io.ReadSeeker
+		switch {
+		case *streamData:
+			name := ""
+			if *uniqueData {
+				name = objectName
+			}
+			body = newStreamBody(generatedPayload{size: size, random: *randomData, seed: uint64(*seed)}, size, name)
+		case *uniqueData:
+			body = newUniqueReader(objData, objectName)
+		default:
+			body = bytes.NewReader(objData)
+		}
+		// sentSize is the size of the body as sent and stored.
+		var compressedSize int64
+		sentSize := size
+		if *compress == "gzip" {
+			// Compressing is part of the upload, as for clients
+			// compressing before they store.
+			zbody, err := gzipBody(body)
+			if err != nil {
+				return 0, 0, "", err
+			}
+			compressedSize = zbody.Size()
+			sentSize = compressedSize
+			body = zbody
+		}
+		expectedMD5 := sharedMD5
+		if (*verify || readAfterWrite) && (*uniqueData || sizes != nil || files != nil || *streamData || *compress == "gzip") {
+			var err error
+			if expectedMD5, err = md5Hex(body); err != nil {
+				return 0, 0, "", err
+			}
+		}
+		endpoint, endpointUploader := pool.pick()
+		var out *s3manager.UploadOutput
+		var err error
+		putStart := time.Now()
+		if *op == "presigned-put" {
+			var etag string
+			etag, err = presignedPut(ctx, endpointUploader.S3, presignClient, *userAgent, objectName, body, size, *urlExpiry)
+			out = &s3manager.UploadOutput{ETag: aws.String(etag)}
+		} else if manual {
+			out, err = manualMultipartUpload(ctx, endpointUploader.S3, uploadInput(body, objectName, objOpts, rng), sentSize, parts)
+		} else {
+			out, err = uploadBlob(ctx, endpointUploader, body, objectName, objOpts, rng)
+		}
+		if err != nil && opts.ifAbsent && isPreconditionFailed(err) {
+			// The key existed and was not written.
+			atomic.AddInt64(&preconditionFailed, 1)
+			return 0, 0, "", refusedUpload{reason: "the key exists"}
+		}
+		if err != nil && opts.acl != "" && isACLUnsupported(err) {
+			// The backend does not do the work measured, the run goes
+			// on and the refusal is reported.
+			atomic.AddInt64(&aclRejected, 1)
+			aclWarning.Do(func() { warnf("The backend refused the %s ACL: %v", opts.acl, err) })
+			return 0, 0, "", refusedUpload{reason: "the ACL is not supported"}
+		}
+		if err == nil && !(manual && *skipComplete) {
+			// The object exists even when its checks below fail.
+			written.add(objectName)
+		}
+		if err == nil && *verify {
+			err = verifyUpload(ctx, endpointUploader.S3, out, objectName, expectedMD5, sentSize)
+		}
+		if err == nil && readAfterWrite {
+			putLat.add(time.Since(putStart))
+			getStart := time.Now()
+			err = readBack(ctx, endpointUploader.S3, objectName, expectedMD5, size)
+			if _, ok := err.(inconsistentRead); ok {
+				atomic.AddInt64(&inconsistentReads, 1)
+			} else if err == nil {
+				getLat.add(time.Since(getStart))
+			}
+		}
+		if err == nil && versions != nil {
+			if werr := versions.record(objectName, aws.StringValue(out.VersionID)); werr != nil {
+				fatalf("Writing %s failed: %v", *recordVers, werr)
+			}
+		}
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("upload timed out after %s", *timeout)
+		}
+		if err != nil {
+			if len(endpoints) > 1 {
+				err = fmt.Errorf("%s: %w", endpoint, err)
+			}
+			return 0, 0, "", err
+		}
+		pool.uploaded(endpoint)
+		atomic.AddInt64(bucketCounts[bucketFor(objectName)], 1)
+		atomic.AddInt64(&compressedBytes, compressedSize)
+		if file != nil {
+			counts := typeCounts[file.contentType]
+			atomic.AddInt64(&counts.objects, 1)
+			atomic.AddInt64(&counts.bytes, size)
+		}
+		return size, sentSize, aws.StringValue(out.ETag), nil
+	}
+	var manifest *manifestWriter
+	if *manifestFile != "" {
+		f, err := os.Create(*manifestFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		manifest = newManifestWriter(f)
+	}
+	var keySet *manifestWriter
+	if *writeKeys != "" {
+		f, err := os.Create(*writeKeys)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		keySet = newManifestWriter(f)
+	}
+	// Warmup objects are left out of the -write-manifest file, the flag
+	// is only written between phases.
+	warmingUp := warmupCount > 0 || warmupDuration > 0
+	metrics := newUploadMetrics()
+	var uploadProgress progress
+	upload := func(objectName string) (int64, error) {
+		uploadStart := time.Now()
+		n, stored, etag, err := doUpload(objectName)
+		uploadLatency := time.Since(uploadStart)
+		if manifest != nil || (keySet != nil && !warmingUp) {
+			entry := newManifestEntry(objectName, n, stored, uploadStart, uploadLatency, etag, err)
+			if manifest != nil {
+				manifest.write(entry)
+			}
+			if keySet != nil && !warmingUp {
+				keySet.write(entry)
+			}
+		}
+		if isRefused(err) {
+			// Neither an upload nor an error of the metrics.
+			debugf("Upload of %s was refused after %s: %v", objectName, uploadLatency, err)
+			return n, err
+		}
+		if err != nil {
+			debugf("Upload of %s failed after %s: %v", objectName, uploadLatency, err)
+		} else {
+			debugf("Uploaded %s in %s", objectName, uploadLatency)
+		}
+		if *slowThresh > 0 && uploadLatency > *slowThresh {
+			atomic.AddInt64(&slowUploads, 1)
+			if ok, dropped := slowLog.allow(time.Now()); ok {
+				if dropped > 0 {
+					warnf("%d more slow uploads were not logged", dropped)
+				}
+				warnf("Upload of %s took %s, above -slow-threshold %s", objectName, uploadLatency, *slowThresh)
+			}
+		}
+		metrics.observe(n, uploadLatency, err)
+		uploadProgress.observe(n, err)
+		return n, err
+	}
+	progressDone := make(chan struct{})
+	if *showProgress > 0 {
+		go uploadProgress.report(*showProgress, progressDone)
+	}
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		if metricsServer, err = metrics.serve(*metricsAddr); err != nil {
+			fatalf("%v", err)
+		}
+		infof("Serving metrics on http://%s/metrics", *metricsAddr)
+	}
+
+	if *outputFile != "" {
+		f, err := openAppendFile(*outputFile)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		resultOut = f
+	}
+	var histogramOut io.Writer
+	if *histogram != "" {
+		f, err := os.Create(*histogram)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		histogramOut = f
+	}
+	var summary *runSummary
+	if *summaryFile != "" {
+		hostname, _ := os.Hostname()
+		summary = &runSummary{
+			SchemaVersion: resultSchemaVersion,
+			Version:       version,
+			Hostname:      hostname,
+			GoVersion:     runtime.Version(),
+			OS:            runtime.GOOS,
+			Arch:          runtime.GOARCH,
+			CPUs:          runtime.NumCPU(),
+			StartTs:       formatTimestamp(time.Now(), *timeFormat),
+			Config:        summaryConfig(endpoints, conc, nodeNumber),
+			Rows:          []summaryRow{},
+		}
+	}
+	var resources *resourceSampler
+	if *resStats {
+		resources = newResourceSampler()
+	}
+	presigned := *op == "presigned-put"
+	columns := shownColumns()
+	printedHeader := false
+	printedHistogram := false
+	limits := sla{p99: *slaP99, minThroughput: *slaMinTput}
+	var slaFailures []string
+	setupReported := false
+	var report func(p phase)
+	report = func(p phase) {
+		if *includeSetup && !setupReported {
+			// The sessions are shared by all rows, only the first
+			// one pays for them.
+			p.start = p.start.Add(-setupTime)
+			p.elapsed += setupTime
+			setupReported = true
+		}
+		op := p.op
+		if op == "" {
+			op = "PUT"
+		}
+		if op == "PUT" && presigned {
+			op = "PRESIGNED-PUT"
+		}
+		if op == "PUT" && manual {
+			op = "MULTIPART-PUT"
+		}
+		// Metadata and the other object settings are sent with these.
+		uploadRow := op == "PUT" || op == "MULTIPART-PUT"
+		size := *objectSize
+		rowPayloadType := payloadType
+		if op == "COPY" {
+			// The bandwidth counts the bytes copied on the server.
+			rowPayloadType = "server-side"
+		}
+		if op == "DELETE" || op == "HEAD" || op == "LIST" {
+			// No object data is transferred.
+			size = 0
+		} else if op == "PART" {
+			// Every operation is a part.
+			if p.count > 0 {
+				size = p.bytes / int64(p.count)
+			}
+		} else if (sizes != nil || files != nil) && p.count > 0 {
+			// Objects differ in size, report the average.
+			size = p.bytes / int64(p.count)
+			infof("%s transferred %d bytes, %d bytes per object on average", op, p.bytes, size)
+		}
+		seconds := float64(p.elapsed) / float64(time.Second)
+		targetRate := *rate
+		if *mix != "" {
+			// The rate limits the mixed jobs together.
+			share := mixPut
+			if op == "GET" {
+				share = mixGet
+			}
+			targetRate = targetRate * float64(share) / float64(mixPut+mixGet)
+		}
+		r := result{
+			SchemaVersion: resultSchemaVersion,
+			Type:          op,
+			Node:          nodeNumber,
+			Concurrency:   p.concurrency,
+			ObjectSize:    size,
+			MetaCount:     *metaCount,
+			MetaSize:      *metaSize,
+			ElapsedMs:     float64(p.elapsed) / float64(time.Millisecond),
+			ObjsPerSec:    float64(p.count) / seconds,
+			MbitPerSec:    float64(p.bytes) / seconds / 1024 / 1024,
+			StartTs:       formatTimestamp(p.start, *timeFormat),
+			EndTs:         formatTimestamp(time.Now(), *timeFormat),
+			PartSize:      *partSize,
+			PayloadType:   rowPayloadType,
+			StorageClass:  *storageClass,
+			Partial:       stopCtx.Err() != nil,
+			TagCount:      tagCount,
+			PartConc:      *partConc,
+			Checksum:      opts.checksum,
+			TotalObjects:  p.count,
+			TotalBytes:    p.bytes,
+			TargetRate:    targetRate,
+			MD5Disabled:   *disableMD5,
+			Version:       version,
+			ErrorRate:     p.errorRate(),
+			Compression:   *compress,
+			SetupIncluded: *includeSetup,
+			Signing:       *payloadSig,
+			elapsed:       p.elapsed,
+			columns:       columns,
+		}
+		if !uploadRow {
+			// Metadata, tags and checksums are only sent on PUT, as in
+			// parallel-get.
+			r.MetaCount, r.MetaSize, r.TagCount, r.Checksum = 0, 0, 0, ""
+		}
+		if uploadRow {
+			// Rows are reported after their phase, so the failures,
+			// ACL rejections, compressed bytes and slow uploads since
+			// the last PUT row belong to this one.
+			failed := atomic.LoadInt64(&preconditionFailed)
+			r.PrecondFailed = failed - reportedFailed
+			reportedFailed = failed
+			rejected := atomic.LoadInt64(&aclRejected)
+			r.ACLRejected = rejected - reportedRejected
+			reportedRejected = rejected
+			compressed := atomic.LoadInt64(&compressedBytes)
+			r.CompressedB = compressed - reportedCompressed
+			reportedCompressed = compressed
+			slow := atomic.LoadInt64(&slowUploads)
+			r.SlowCount = slow - reportedSlow
+			reportedSlow = slow
+			measuredPuts += p.elapsed
+		} else {
+			// Only the bodies of uploads are signed differently.
+			r.Compression, r.Signing = "none", "signed"
+		}
+		// Every operation may be throttled, the throttled attempts since
+		// the last row belong to this one.
+		throttledNow := atomic.LoadInt64(&throttled)
+		r.Throttled = throttledNow - reportedThrottled
+		reportedThrottled = throttledNow
+		if targetRate > 0 {
+			r.RateSustained = r.ObjsPerSec >= rateSustainedShare*targetRate
+		}
+		if *latency || op == "HEAD" || op == "PART" {
+			r.Latency = p.lat.stats()
+		}
+		if op == "LIST" {
+			r.List = p.list
+		}
+		if op == "PUT-GET" {
+			r.Inconsistent = atomic.LoadInt64(&inconsistentReads)
+		}
+		if resources != nil {
+			r.Resources = resources.sample()
+		}
+		if *perWorker {
+			logWorkerStats(op, p)
+		}
+		if err := printResult(resultOut, r, *output, *header && !printedHeader); err != nil {
+			fatalf("Writing the result failed: %v", err)
+		}
+		if histogramOut != nil {
+			if err := writeHistogram(histogramOut, r, p.lat.histogram(), !printedHistogram); err != nil {
+				fatalf("Writing %s failed: %v", *histogram, err)
+			}
+			printedHistogram = true
+		}
+		if summary != nil {
+			summary.Rows = append(summary.Rows, summaryRow{result: r, Histogram: p.lat.histogram()})
+		}
+		printedHeader = true
+		lat := r.Latency
+		if lat == nil && limits.p99 > 0 {
+			lat = p.lat.stats()
+		}
+		if op != "PART" {
+			slaFailures = append(slaFailures, limits.violations(r, lat)...)
+		}
+		if op == "MULTIPART-PUT" {
+			// The parts since the last row follow in a row of their
+			// own, with their latencies.
+			uploadedParts, uploadedBytes := atomic.LoadInt64(&partsUploaded), atomic.LoadInt64(&partBytes)
+			partPhase := phase{op: "PART", concurrency: p.concurrency, lat: parts.lat, start: p.start, elapsed: p.elapsed, count: int(uploadedParts - reportedParts), bytes: uploadedBytes - reportedPartB}
+			reportedParts, reportedPartB = uploadedParts, uploadedBytes
+			parts.lat = &latencies{}
+			report(partPhase)
+		}
+	}
+
+	var count int
+	var errs []error
+	var limit *rateLimiter
+	if *rate > 0 {
+		limit = newRateLimiter(*rate)
+		infof("Limiting the rate to %f objs/sec", *rate)
+	}
+	runStart := time.Now().Unix()
+	var iterationNames []*nameSequence
+	names := &nameSequence{nodeNumber: nodeNumber, template: *keyTemplate, ts: runStart, prefixes: *prefixCount}
+	// Warmup objects are named apart so that they do not shift the names
+	// of the measured objects.
+	warmupNames := &nameSequence{nodeNumber: "warmup-" + nodeNumber, template: *keyTemplate, ts: runStart, prefixes: *prefixCount}
+	if warmupCount > 0 || warmupDuration > 0 {
+		var p phase
+		if warmupCount > 0 {
+			var warmupObjects []string
+			for i := 0; i < warmupCount; i++ {
+				warmupObjects = append(warmupObjects, warmupNames.next())
+			}
+			p = parallelUploads(stopCtx, warmupObjects, *workers, upload, nil, *failFast)
+		} else {
+			p = timedUploads(stopCtx, warmupNames, conc, warmupDuration, upload, nil, *failFast)
+		}
+		infof("Warmup uploaded %d objects in %s, %d failed", p.count, p.elapsed, len(p.errs))
+		warmingUp = false
+		reportedFailed = atomic.LoadInt64(&preconditionFailed)
+		reportedRejected = atomic.LoadInt64(&aclRejected)
+		reportedCompressed = atomic.LoadInt64(&compressedBytes)
+		reportedSlow = atomic.LoadInt64(&slowUploads)
+		reportedThrottled = atomic.LoadInt64(&throttled)
+		if resources != nil {
+			resources.reset()
+		}
+		for _, counts := range typeCounts {
+			atomic.StoreInt64(&counts.objects, 0)
+			atomic.StoreInt64(&counts.bytes, 0)
+		}
+	}
+	switch {
+	case *op == "delete":
+		objectNames := names.take(objects, shuffled)
+		p := phase{op: "DELETE", concurrency: rowConc, lat: &latencies{}, start: time.Now().UTC()}
+		var notFound int
+		p.count, notFound, p.errs = parallelDeletes(stopCtx, uploader.S3, objectNames, *batchSize, *workers, p.lat, *failFast)
+		p.elapsed = time.Since(p.start)
+		if notFound > 0 {
+			warnf("%d objects did not exist", notFound)
+		}
+		report(p)
+		count, errs = p.count+notFound, p.errs
+	case *op == "list":
+		p := phase{op: "LIST", concurrency: 1, lat: &latencies{}, start: time.Now().UTC()}
+		stats, err := listObjects(stopCtx, uploader.S3, *prefix, *pageSize, *maxKeysTotal, p.lat)
+		p.elapsed = time.Since(p.start)
+		p.count, p.list = stats.Keys, &stats
+		if err != nil {
+			p.errs = []error{err}
+		}
+		infof("Listed %d keys in %d pages", stats.Keys, stats.Pages)
+		report(p)
+		count, errs = p.count, p.errs
+	case *op == "head":
+		objectNames := names.take(objects, shuffled)
+		head := func(objectName string) (int64, error) {
+			_, err := uploader.S3.HeadObjectWithContext(uploadCtx, &s3.HeadObjectInput{
+				Bucket: aws.String(bucketFor(objectName)),
+				Key:    aws.String(objectName),
+			})
+			return 0, err
+		}
+		p := parallelUploads(stopCtx, objectNames, *workers, head, limit, *failFast)
+		p.op, p.concurrency = "HEAD", rowConc
+		report(p)
+		count, errs = p.count, p.errs
+	case *op == "copy":
+		objectNames := names.take(objects, shuffled)
+		var missing int64
+		copyObject := func(objectName string) (int64, error) {
+			if err := copyBlob(uploadCtx, uploader.S3, objectName); err != nil {
+				if isNotFound(err) {
+					atomic.AddInt64(&missing, 1)
+				}
+				return 0, err
+			}
+			written.add(copyName(objectName))
+			// Logical bytes, nothing is transferred by the client.
+			return *objectSize, nil
+		}
+		p := parallelUploads(stopCtx, objectNames, *workers, copyObject, limit, *failFast)
+		p.op, p.concurrency = "COPY", rowConc
+		if missing > 0 {
+			warnf("%d copies failed because the source object did not exist", missing)
+		}
+		report(p)
+		count, errs = p.count, p.errs
+	case readAfterWrite:
+		var p phase
+		if *duration > 0 {
+			p = timedUploads(stopCtx, names, conc, *duration, upload, limit, *failFast)
+		} else {
+			objectNames := names.take(objects, shuffled)
+			p = parallelUploads(stopCtx, objectNames, *workers, upload, limit, *failFast)
+			p.concurrency = rowConc
+		}
+		// Every object was uploaded and read back within the phase, its
+		// PUT and GET rows only differ by their latencies.
+		puts, gets := p, p
+		puts.op, puts.lat = "PUT", putLat
+		gets.op, gets.lat = "GET", getLat
+		p.op = "PUT-GET"
+		report(puts)
+		report(gets)
+		report(p)
+		if n := atomic.LoadInt64(&inconsistentReads); n > 0 {
+			warnf("%d reads right after the upload did not find the object or returned other bytes", n)
+		}
+		count, errs = p.count, p.errs
+	case *mix != "":
+		// Without downloads nothing is seeded.
+		var seeded []string
+		if mixGet > 0 {
+			seeded = make([]string, *mixSeed)
+		}
+		for i := range seeded {
+			seeded[i] = names.next()
+		}
+		seedStart := time.Now()
+		if p := parallelUploads(stopCtx, seeded, *workers, upload, nil, true); len(p.errs) > 0 {
+			fatalf("Seeding the objects to download failed: %v", p.errs[0])
+		}
+		infof("Seeded %d objects in %s", len(seeded), time.Since(seedStart))
+
+		download := func(objectName string) (int64, error) {
+			return downloadBlob(uploadCtx, uploader.S3, objectName)
+		}
+		mixWorkers := conc
+		if *workers > 0 {
+			mixWorkers = *workers
+		}
+		puts, gets := mixedOps(stopCtx, names, seeded, mixWorkers, objects, *duration, mixPut, mixGet, upload, download, limit, *failFast)
+		report(puts)
+		report(gets)
+		count = puts.count + gets.count
+		errs = append(puts.errs, gets.errs...)
+	case ramping:
+		schedule := rampSchedule{
+			start:          *rampStart,
+			step:           *rampStep,
+			max:            conc,
+			interval:       *rampInterval,
+			errorThreshold: *rampErrors,
+		}
+		if *rampMode == "exponential" {
+			schedule.factor = *rampFactor
+		}
+		count, errs = rampUploads(stopCtx, names, schedule, upload, limit, *failFast, report)
+	case *autotune:
+		settings := autotuneSettings{max: conc, probe: *tuneProbe, gain: *tuneGain, p99: *tuneP99}
+		var best phase
+		best, count, errs = autotuneUploads(stopCtx, names, settings, upload, limit, *failFast, report)
+		infof("Peak throughput of %f objs/sec, %f MBit/sec, with %d workers", best.throughput(), float64(best.bytes)/best.elapsed.Seconds()/1024/1024, best.concurrency)
+	default:
+		var rates []float64
+		if *iterations > 1 {
+			if *freshConns {
+				infof("Opening new connections for every iteration")
+			} else {
+				infof("Reusing the connections across iterations")
+			}
+		}
+		for it := 1; it <= *iterations && stopCtx.Err() == nil; it++ {
+			if *freshConns && it > 1 {
+				// Every iteration pays the connection setup, as a
+				// cold client does.
+				pool = pool.renew(sessOpts, *partSize, *partConc)
+				if presignClient != nil {
+					presignClient.CloseIdleConnections()
+					presignClient = newHTTPClient(sessOpts)
+				}
+			}
+			iterNames := names
+			if *iterations > 1 {
+				// Every iteration uploads new objects.
+				iterNames = &nameSequence{nodeNumber: nodeNumber, template: *keyTemplate, suffix: fmt.Sprintf("-iter%d", it), ts: runStart, prefixes: *prefixCount}
+				iterationNames = append(iterationNames, iterNames)
+			}
+			var p phase
+			if *duration > 0 {
+				p = timedUploads(stopCtx, iterNames, conc, *duration, upload, limit, *failFast)
+				infof("Uploaded %d objects in %s", p.count, p.elapsed)
+			} else {
+				objectNames := iterNames.take(objects, shuffled)
+				p = parallelUploads(stopCtx, objectNames, *workers, upload, limit, *failFast)
+				p.concurrency = rowConc
+			}
+			report(p)
+			count += p.count
+			errs = append(errs, p.errs...)
+			rates = append(rates, float64(p.count)/p.elapsed.Seconds())
+		}
+		if len(rates) > 1 {
+			mean, stddev := meanStddev(rates)
+			infof("Speed over %d iterations: mean %f objs/sec, standard deviation %f objs/sec", len(rates), mean, stddev)
+		}
+	}
+	close(progressDone)
+	if resources != nil {
+		resources.stop()
+	}
+	if manifest != nil {
+		if err := manifest.close(); err != nil {
+			errorf("Writing %s failed: %v", *manifestFile, err)
+		}
+	}
+	if keySet != nil {
+		if err := keySet.close(); err != nil {
+			errorf("Writing %s failed: %v", *writeKeys, err)
+		}
+	}
+	infof("Retried %d requests, %d attempts were throttled", atomic.LoadInt64(&retried), atomic.LoadInt64(&throttled))
+	if n := atomic.LoadInt64(&aclRejected); n > 0 {
+		warnf("%d uploads were refused because the backend does not support the %s ACL", n, opts.acl)
+	}
+	if *dialTimeout > 0 || *headerTmout > 0 {
+		infof("%d requests timed out connecting, %d waiting for the response headers", atomic.LoadInt64(&timeouts.dial), atomic.LoadInt64(&timeouts.responseHeader))
+	}
+	if versions != nil {
+		if err := versions.flush(); err != nil {
+			errorf("Writing %s failed: %v", *recordVers, err)
+		}
+		if versions.unversioned > 0 {
+			warnf("%d uploads returned no version id, the bucket may not have versioning enabled", versions.unversioned)
+		}
+	}
+	if len(endpoints) > 1 {
+		for _, endpoint := range endpoints {
+			infof("Uploaded %d objects to %s", atomic.LoadInt64(pool.counts[endpoint]), endpoint)
+		}
+	}
+	if len(buckets) > 1 {
+		for _, bucket := range buckets {
+			infof("Uploaded %d objects to bucket %s", atomic.LoadInt64(bucketCounts[bucket]), bucket)
+		}
+	}
+	if *prefixCount > 0 {
+		counts := make([]int, *prefixCount)
+		names.prefixCounts(counts)
+		for _, iterNames := range iterationNames {
+			iterNames.prefixCounts(counts)
+		}
+		logPrefixSpread(counts)
+	}
+	if files != nil && measuredPuts > 0 {
+		seconds := measuredPuts.Seconds()
+		for _, contentType := range files.contentTypes() {
+			counts := typeCounts[contentType]
+			objects, bytes := atomic.LoadInt64(&counts.objects), atomic.LoadInt64(&counts.bytes)
+			infof("Uploaded %d %s objects, %f objs/sec, %f MBit/sec", objects, contentType, float64(objects)/seconds, float64(bytes)/seconds/1024/1024)
+		}
+	}
+
+	if n := atomic.LoadInt64(&aborted); n > 0 {
+		infof("Aborted %d failed multipart uploads", n)
+	}
+	if manual && *skipComplete && count > 0 {
+		infof("Left the multipart uploads of %d objects incomplete, -abort-incomplete aborts them", count)
+	}
+	if *abortLeft {
+		// Uploads started from now on belong to other clients.
+		swept, err := abortIncompleteUploads(cleanupCtx, uploader.S3, allBuckets(), *prefix, time.Now())
+		infof("Aborted %d incomplete multipart uploads left in the buckets", swept)
+		if err != nil {
+			errorf("Aborting the incomplete multipart uploads failed: %v", err)
+		}
+	}
+	if *cleanup {
+		cleanupStart := time.Now()
+		cleanupNames := written.all()
+		deleted, err := deleteObjects(cleanupCtx, uploader.S3, cleanupNames)
+		infof("Deleted %d objects in %s", deleted, time.Since(cleanupStart))
+		if err != nil && cleanupCtx.Err() == context.DeadlineExceeded {
+			warnf("Reached -max-runtime %s during the cleanup, %d objects were not deleted", *maxRuntime, len(cleanupNames)-deleted)
+		} else if err != nil {
+			errorf("Cleanup failed: %v", err)
+		}
+	}
+	if metricsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			warnf("Shutting down the metrics server failed: %v", err)
+		}
+		cancel()
+	}
+	if summary != nil {
+		summary.EndTs = formatTimestamp(time.Now(), *timeFormat)
+		summary.Errors = errorBreakdown(errs)
+		summary.SLAFailures = append([]string{}, slaFailures...)
+		b, err := json.MarshalIndent(summary, "", "  ")
+		if err == nil {
+			err = writeFileAtomic(*summaryFile, append(b, '\n'))
+		}
+		if err != nil {
+			errorf("Writing %s failed: %v", *summaryFile, err)
+		}
+	}
+	if len(errs) > 0 {
+		errorf("%d of %d operations failed", len(errs), count+len(errs))
+		for i, err := range errs {
+			if i == maxReportedErrors {
+				errorf("... and %d more", len(errs)-maxReportedErrors)
+				break
+			}
+			errorf("%v", err)
+		}
+		os.Exit(1)
+	}
+	if len(slaFailures) > 0 {
+		for _, failure := range slaFailures {
+			errorf("SLA failed: %s", failure)
+		}
+		os.Exit(slaExitCode)
+	}
+}